@@ -0,0 +1,204 @@
+package app
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Archiver is an archiving engine used by Job to create, unpack and check archives.
+type Archiver interface {
+	// Archive file extension with leading dot (".7z" for example)
+	Extension() string
+
+	// Checks if archiver is ready to work with current settings (external tools available etc.)
+	Check() error
+
+	// Creates full archive with all job directory files
+	CreateFull(archive_path string) error
+
+	// Creates differential archive with changes since base_path full archive.
+	// Returns true if no changes were found (empty archive created).
+	CreateDiff(archive_path string, base_path string) (bool, error)
+
+	// Creates archive with given files only (paths are relative to job directory, slash separated)
+	CreateFromList(archive_path string, files []string) error
+
+	// Unpacks archive to directory. overwrite = replace existing files without asking.
+	// If paths are given only items matching them are unpacked (see MatchPathPatterns).
+	Extract(archive_path string, to string, overwrite bool, paths []string) error
+
+	// Returns list of items packed to archive
+	List(archive_path string) ([]ArchiveEntry, error)
+
+	// Tests archive integrity
+	Test(archive_path string) error
+}
+
+// ArchiverCommandLines is implemented by archivers running external programs. Returns command lines
+// archiver would run to create archive: full one if base_path is empty, differential one otherwise,
+// or archive with listed files only if from_list is set. Used by dry run.
+type ArchiverCommandLines interface {
+	CommandLines(archive_path string, base_path string, from_list bool) []string
+}
+
+// ArchiverPackedFiles is implemented by archivers packing files by themselves. Returns files packed
+// to last created archive mapped by path (slash separated, relative to job directory). Files which
+// were not packed completely are not listed.
+type ArchiverPackedFiles interface {
+	PackedFiles() map[string]PackedFile
+}
+
+// PackedFile describes file as it was packed to archive.
+type PackedFile struct {
+	Mode   fs.FileMode
+	Sha256 string //packed content hash (empty for symlinks)
+}
+
+// ArchiveEntry is single item (file or directory) packed to archive.
+type ArchiveEntry struct {
+	Path    string    //slash separated, relative to job directory
+	Size    int64     //unpacked size
+	ModTime time.Time //modification time
+	IsDir   bool      //directory item
+	Deleted bool      //"anti-item": file was deleted since base archive
+}
+
+// safe path for archive entry inside destination directory
+func entryTargetPath(to string, name string) (string, error) {
+	clean := path.Clean("/" + name)
+
+	if clean == "/" {
+		return "", fmt.Errorf("wrong archive entry name: %s", name)
+	}
+
+	return filepath.Join(to, filepath.FromSlash(clean)), nil
+}
+
+// checks that there are no symlinks between destination directory and entry target path (target itself
+// is not checked). Otherwise entry would be written outside destination directory through symlink unpacked before.
+func checkTargetParents(to string, target string) error {
+	rel_path, err := filepath.Rel(to, filepath.Dir(target))
+	if err != nil || rel_path == "." {
+		return err
+	}
+
+	parent := to
+
+	for _, name := range strings.Split(rel_path, string(filepath.Separator)) {
+		parent = filepath.Join(parent, name)
+
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			//rest of directories is created
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("can not unpack %s: parent directory %s is symlink", target, parent)
+		}
+	}
+
+	return nil
+}
+
+// MatchPathPatterns checks if archive item path (slash separated) matches any of patterns.
+// Pattern can be exact path, glob pattern or directory (all items inside it match).
+// Empty patterns list matches everything.
+func MatchPathPatterns(patterns []string, rel_path string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.Trim(strings.TrimPrefix(filepath.ToSlash(pattern), "./"), "/")
+
+		//check path itself and all its parent directories
+		for p := rel_path; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+			if matched, _ := path.Match(pattern, p); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ArchiverFactory creates archiver instance for job.
+type ArchiverFactory func(job *Job) Archiver
+
+// known archivers by name (used in 'archiver' setting)
+var archiverFactories = map[string]ArchiverFactory{}
+
+// Default value for 'archiver' setting
+const DefaultArchiver = "7z"
+
+func init() {
+	RegisterArchiver(DefaultArchiver, newSevenZipArchiver)
+}
+
+// RegisterArchiver makes archiver available by name for 'archiver' setting.
+func RegisterArchiver(name string, factory ArchiverFactory) {
+	archiverFactories[name] = factory
+}
+
+// ArchiverNames returns sorted list of registered archiver names.
+func ArchiverNames() []string {
+	names := make([]string, 0, len(archiverFactories))
+
+	for name := range archiverFactories {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// creates archiver for job by name from settings
+func newArchiver(job *Job) (Archiver, error) {
+	factory, ok := archiverFactories[job.Settings.Archiver]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown archiver: %s", job.Settings.Archiver)
+	}
+
+	return factory(job), nil
+}
+
+// creates archiver able to handle archive file (chosen by file extension)
+func (job *Job) archiverForFile(archive_path string) (Archiver, error) {
+	if job.Archiver != nil && strings.HasSuffix(archive_path, job.Archiver.Extension()) {
+		return job.Archiver, nil
+	}
+
+	for _, name := range ArchiverNames() {
+		archiver := archiverFactories[name](job)
+
+		if strings.HasSuffix(archive_path, archiver.Extension()) {
+			return archiver, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no archiver found for file: %s", archive_path)
+}
+
+// returns extensions of all registered archivers
+func archiverExtensions(job *Job) []string {
+	list := make([]string, 0, len(archiverFactories))
+
+	for _, name := range ArchiverNames() {
+		list = append(list, archiverFactories[name](job).Extension())
+	}
+
+	return list
+}
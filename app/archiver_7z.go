@@ -0,0 +1,388 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 7-Zip based archiver. Uses external 7-Zip executable (JobOptions.SevenZipCmd).
+type sevenZipArchiver struct {
+	job *Job
+}
+
+func newSevenZipArchiver(job *Job) Archiver {
+	return &sevenZipArchiver{job: job}
+}
+
+func (a *sevenZipArchiver) Extension() string {
+	return ".7z"
+}
+
+func (a *sevenZipArchiver) Check() error {
+	if a.job.Options.SevenZipCmd == "" {
+		return errors.New("Can not find 7-Zip. Please provide correct path with --7zip flag.")
+	}
+
+	return nil
+}
+
+func (a *sevenZipArchiver) CreateFull(archive_path string) error {
+	var err error
+
+	// errors are logged but second pass is run anyway
+	for _, arguments := range a.fullArguments(archive_path) {
+		//failure is more important than warning
+		if _, run_err := a.run(arguments); run_err != nil && (err == nil || IsWarning(err)) {
+			err = run_err
+		}
+	}
+
+	return err
+}
+
+// 7-zip runs to create full archive: basic compression and adding skip_compression items without compression
+func (a *sevenZipArchiver) fullArguments(archive_path string) [][]string {
+	js := &a.job.Settings //convenience variable
+
+	common_arguments := a.commonArguments("a", archive_path)
+
+	//// RUN BASIC COMPRESSION
+	var basic_arguments = make([]string, len(common_arguments))
+	copy(basic_arguments, common_arguments)
+
+	basic_arguments = append(basic_arguments,
+		"-mx"+strconv.Itoa(js.CompressionLevel), //compression level
+	)
+
+	// exclude skip_compression patterns
+	for _, pattern := range js.SkipCompression {
+		basic_arguments = append(basic_arguments, "-xr!"+pattern)
+	}
+
+	// final argument - whole folder to pack
+	basic_arguments = append(basic_arguments, filepath.Join(a.job.Path, "*"))
+
+	runs := [][]string{basic_arguments}
+
+	//// ADD ITEMS WITHOUT COMPRESSION - works only for full archives now
+	if len(js.SkipCompression) > 0 {
+		var skip_compression_arguments = make([]string, len(common_arguments))
+		copy(skip_compression_arguments, common_arguments)
+
+		skip_compression_arguments = append(skip_compression_arguments,
+			"-m0=copy", //do not compress at all
+		)
+
+		// add skip_compression patterns
+		for _, pattern := range js.SkipCompression {
+			skip_compression_arguments = append(skip_compression_arguments, filepath.Join(a.job.Path, pattern))
+		}
+
+		runs = append(runs, skip_compression_arguments)
+	}
+
+	return runs
+}
+
+func (a *sevenZipArchiver) CreateDiff(archive_path string, base_path string) (bool, error) {
+	output, err := a.run(a.diffArguments(archive_path, base_path))
+
+	return strings.Contains(output, "Add new data to archive: 0 files, 0 bytes"), err
+}
+
+// 7-zip arguments to create differential archive
+func (a *sevenZipArchiver) diffArguments(archive_path string, base_path string) []string {
+	// thanks: https://nagimov.me/post/simple-differential-and-incremental-backups-using-7-zip/
+	arguments := a.commonArguments(
+		"u",
+		base_path, //existing full archive
+		"-u-",     // disable updates in the base archive
+		"-up3q3r2x2y2z0w2!"+archive_path,
+	)
+
+	arguments = append(arguments,
+		"-mx"+strconv.Itoa(a.job.Settings.CompressionLevel), //compression level
+		filepath.Join(a.job.Path, "*"),                      // final argument - whole folder to pack
+	)
+
+	return arguments
+}
+
+func (a *sevenZipArchiver) Extract(archive_path string, to string, overwrite bool, paths []string) error {
+	var arguments = []string{
+		"x",       // 7-zip command (eXtract)
+		"-o" + to, // Output directory
+	}
+
+	arguments = a.appendPassword(arguments)
+
+	if overwrite {
+		arguments = append(arguments, "-aoa") //Overwrite all existing files without prompt
+	}
+
+	arguments = append(arguments, archive_path)
+
+	//7-zip matches directories with all their contents and wildcards by itself
+	for _, pattern := range paths {
+		arguments = append(arguments, filepath.FromSlash(strings.TrimPrefix(pattern, "./")))
+	}
+
+	if len(paths) > 0 {
+		arguments = append(arguments, "-r-") //no recursive wildcards matching
+	}
+
+	_, err := a.run(arguments)
+
+	return err
+}
+
+func (a *sevenZipArchiver) CreateFromList(archive_path string, files []string) error {
+	//7-zip is run from job directory so archive path should not be relative
+	archive_path, err := filepath.Abs(archive_path)
+	if err != nil {
+		return err
+	}
+
+	list_file, err := os.CreateTemp("", Global.AppName+"_*.lst")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list_file.Name())
+
+	for _, rel_path := range files {
+		if _, err = list_file.WriteString(filepath.FromSlash(rel_path) + "\n"); err != nil {
+			list_file.Close()
+			return err
+		}
+	}
+
+	if err = list_file.Close(); err != nil {
+		return err
+	}
+
+	_, err = a.exec(a.job.Path, a.listArguments(archive_path, list_file.Name()), true)
+
+	return err
+}
+
+// 7-zip arguments to create archive with files listed in list_filename
+func (a *sevenZipArchiver) listArguments(archive_path string, list_filename string) []string {
+	arguments := a.commonArguments("a", archive_path)
+
+	arguments = append(arguments,
+		"-mx"+strconv.Itoa(a.job.Settings.CompressionLevel), //compression level
+		"-scsUTF-8",       //list file encoding
+		"@"+list_filename, //files to pack
+	)
+
+	return arguments
+}
+
+// CommandLines returns 7-zip command lines to create archive (full one if base_path is empty,
+// differential one otherwise, or from files list if from_list is set).
+func (a *sevenZipArchiver) CommandLines(archive_path string, base_path string, from_list bool) []string {
+	var runs [][]string
+
+	if from_list {
+		archive_path, _ = filepath.Abs(archive_path)
+		runs = [][]string{a.listArguments(archive_path, "<list of changed files>")}
+	} else if base_path == "" {
+		runs = a.fullArguments(archive_path)
+	} else {
+		runs = [][]string{a.diffArguments(archive_path, base_path)}
+	}
+
+	lines := make([]string, 0, len(runs))
+	for _, arguments := range runs {
+		lines = append(lines, a.commandLine(arguments))
+	}
+
+	return lines
+}
+
+func (a *sevenZipArchiver) List(archive_path string) ([]ArchiveEntry, error) {
+	arguments := []string{
+		"l",         // 7-zip command (List)
+		"-ba",       // no headers
+		"-slt",      // technical information (one "Path = ..." line per item)
+		"-sccUTF-8", //console output encoding
+	}
+
+	arguments = a.appendPassword(arguments)
+
+	//listing is not printed to screen
+	output, err := a.exec("", append(arguments, archive_path), false)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]ArchiveEntry, 0)
+	var entry *ArchiveEntry
+
+	//items are blocks of "Key = Value" lines, each one starts with "Path = ..."
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), " = ")
+		if !found {
+			continue
+		}
+
+		if key == "Path" {
+			list = append(list, ArchiveEntry{Path: filepath.ToSlash(value)})
+			entry = &list[len(list)-1]
+			continue
+		}
+
+		if entry == nil {
+			continue
+		}
+
+		switch key {
+		case "Size":
+			entry.Size, _ = strconv.ParseInt(value, 10, 64)
+		case "Modified":
+			entry.ModTime, _ = time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+		case "Folder":
+			entry.IsDir = value == "+"
+		case "Attributes":
+			entry.IsDir = entry.IsDir || strings.HasPrefix(value, "D")
+		case "Anti":
+			entry.Deleted = value == "+"
+		}
+	}
+
+	return list, nil
+}
+
+func (a *sevenZipArchiver) Test(archive_path string) error {
+	arguments := a.appendPassword([]string{
+		"t",         // 7-zip command (Test)
+		"-sccUTF-8", //console output encoding
+	})
+
+	output, err := a.run(append(arguments, archive_path))
+
+	if err != nil && strings.Contains(output, "Wrong password") {
+		return errors.New("wrong password")
+	}
+
+	return err
+}
+
+// 7-zip command (add or update) with basic compression settings
+func (a *sevenZipArchiver) commonArguments(command ...string) []string {
+	js := &a.job.Settings //convenience variable
+
+	arguments := append([]string{}, command...)
+
+	arguments = append(arguments,
+		"-t7z",      //archive type (archives are created with temporary extension)
+		"-r0",       //recursion only for patterns with wildcard
+		"-ssw",      //compress files open for writing
+		"-bb1",      //show names of processed files
+		"-bse1",     //error messages to stdout
+		"-sccUTF-8", //console output encoding
+	)
+
+	//turn on solid mode for archives
+	if js.Solid {
+		arguments = append(arguments, "-ms=on")
+	}
+
+	//set Multithread Mode
+	if js.MultithreadCompressionMode != "" {
+		arguments = append(arguments, "-mmt="+js.MultithreadCompressionMode)
+	}
+
+	//set password for archive
+	if len(js.Password) > 0 {
+		arguments = append(arguments, "-p"+js.Password)
+
+		if js.EncryptFilenames {
+			//mhe = encrypt headers
+			arguments = append(arguments, "-mhe")
+		}
+	}
+
+	//exclusions
+	for _, pattern := range js.Exclude {
+		arguments = append(arguments, "-xr!"+pattern)
+	}
+
+	return arguments
+}
+
+func (a *sevenZipArchiver) appendPassword(arguments []string) []string {
+	if len(a.job.Settings.Password) > 0 {
+		arguments = append(arguments, "-p"+a.job.Settings.Password) // archive password
+	}
+
+	return arguments
+}
+
+// command line to show in logs (password is hidden)
+func (a *sevenZipArchiver) commandLine(arguments []string) string {
+	list := make([]string, 0, len(arguments))
+
+	for _, argument := range arguments {
+		if len(a.job.Settings.Password) > 0 && argument == "-p"+a.job.Settings.Password {
+			argument = "-p***"
+		}
+
+		list = append(list, argument)
+	}
+
+	return a.job.Options.SevenZipCmd + " " + strings.Join(list, " ")
+}
+
+func (a *sevenZipArchiver) run(arguments []string) (string, error) {
+	return a.exec("", arguments, true)
+}
+
+// Runs 7-zip in dir (empty = current directory). print = show output on screen while running.
+func (a *sevenZipArchiver) exec(dir string, arguments []string, print bool) (string, error) {
+	a.job.Log("Command line: %s", a.commandLine(arguments))
+
+	var output strings.Builder
+
+	//7-zip is killed if job context is canceled
+	cmd := exec.CommandContext(a.job.context(), a.job.Options.SevenZipCmd, arguments...)
+	cmd.Dir = dir
+
+	if print {
+		cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+	} else {
+		cmd.Stdout = &output
+	}
+	cmd.Stderr = cmd.Stdout
+
+	err := cmd.Run()
+
+	if err != nil {
+		var exit_error *exec.ExitError
+
+		// exit code 1 = warning (locked files for example), 2 and more = fatal error
+		if ctx_err := a.job.context().Err(); ctx_err != nil {
+			a.job.Log("7-zip was stopped: %s", ctx_err.Error())
+			err = ctx_err
+		} else if errors.As(err, &exit_error) && exit_error.ExitCode() == 1 {
+			a.job.Log("7-zip finished with warnings (exit code 1)")
+			err = &Warning{Err: errors.New("7-zip finished with warnings, some files were not processed")}
+		} else {
+			a.job.Log("Error running 7-zip: %s", err.Error())
+		}
+	}
+
+	if print && a.job.Settings.LogCommandOutput {
+		a.job.RawLog(output.String())
+	}
+
+	return output.String(), err
+}
@@ -0,0 +1,545 @@
+package app
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Native Go archiver: tar stream compressed with zstd. Requires no external tools.
+// Keeps unix permissions, ownership, symlinks and modification times.
+type tarZstdArchiver struct {
+	job    *Job
+	packed map[string]PackedFile //files packed to last created archive
+}
+
+func init() {
+	RegisterArchiver("tar.zst", newTarZstdArchiver)
+}
+
+func newTarZstdArchiver(job *Job) Archiver {
+	return &tarZstdArchiver{job: job}
+}
+
+func (a *tarZstdArchiver) Extension() string {
+	return ".tar.zst"
+}
+
+func (a *tarZstdArchiver) Check() error {
+	if len(a.job.Settings.Password) > 0 {
+		return errors.New("tar.zst archiver does not support password protection")
+	}
+
+	return nil
+}
+
+func (a *tarZstdArchiver) CreateFull(archive_path string) error {
+	_, err := a.create(archive_path, nil)
+
+	return err
+}
+
+func (a *tarZstdArchiver) CreateDiff(archive_path string, base_path string) (bool, error) {
+	var base map[string]ArchiveEntry
+
+	//manifest is much faster than reading whole base archive
+	if manifest, err := LoadManifest(base_path); err == nil {
+		base = manifest.State()
+	} else {
+		a.job.Log("Reading base archive file list: %s", base_path)
+
+		entries, err := a.List(base_path)
+		if err != nil {
+			return false, err
+		}
+
+		base = make(map[string]ArchiveEntry, len(entries))
+		for _, entry := range entries {
+			base[entry.Path] = entry
+		}
+	}
+
+	count, err := a.create(archive_path, func(header *tar.Header) bool {
+		entry, ok := base[header.Name]
+
+		if !ok {
+			return true //new file
+		}
+
+		return entry.Size != header.Size || !sameModTime(entry.ModTime, header.ModTime)
+	})
+
+	return count == 0, err
+}
+
+func (a *tarZstdArchiver) Extract(archive_path string, to string, overwrite bool, paths []string) error {
+	f, err := os.Open(archive_path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+
+	// directories modification times are set after all files are unpacked
+	dir_headers := make([]*tar.Header, 0)
+
+	for {
+		if err := a.job.context().Err(); err != nil {
+			return err
+		}
+
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", filepath.Base(archive_path), err)
+		}
+
+		if !MatchPathPatterns(paths, strings.TrimSuffix(header.Name, "/")) {
+			continue
+		}
+
+		target, err := entryTargetPath(to, header.Name)
+		if err != nil {
+			return err
+		}
+
+		if !overwrite && header.Typeflag != tar.TypeDir {
+			if _, err := os.Lstat(target); err == nil {
+				return fmt.Errorf("file already exists: %s", target)
+			}
+		}
+
+		if err := checkTargetParents(to, target); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return err
+		}
+
+		//existing symlink is replaced, nothing is written through it
+		if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0777); err != nil {
+				return err
+			}
+
+			dir_headers = append(dir_headers, header)
+
+			continue
+
+		case tar.TypeSymlink:
+			//remove existing file to replace it with link
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+
+			if err := out.Close(); err != nil {
+				return err
+			}
+
+		default:
+			a.job.Log("Skipping unsupported entry type (%c): %s", header.Typeflag, header.Name)
+			continue
+		}
+
+		tarRestoreAttributes(target, header)
+	}
+
+	for _, header := range dir_headers {
+		target, _ := entryTargetPath(to, header.Name)
+		tarRestoreAttributes(target, header)
+	}
+
+	return nil
+}
+
+func (a *tarZstdArchiver) List(archive_path string) ([]ArchiveEntry, error) {
+	headers, err := a.readHeaders(archive_path)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]ArchiveEntry, 0, len(headers))
+
+	for name, header := range headers {
+		list = append(list, ArchiveEntry{
+			Path:    strings.TrimSuffix(name, "/"),
+			Size:    header.Size,
+			ModTime: header.ModTime,
+			IsDir:   header.Typeflag == tar.TypeDir,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+
+	return list, nil
+}
+
+func (a *tarZstdArchiver) Test(archive_path string) error {
+	//reading whole stream checks both tar structure and zstd checksums
+	_, err := a.readHeaders(archive_path)
+
+	return err
+}
+
+func (a *tarZstdArchiver) CreateFromList(archive_path string, files []string) error {
+	w, err := a.openWriter(archive_path)
+	if err != nil {
+		return err
+	}
+
+	for _, rel_path := range files {
+		if err := a.job.context().Err(); err != nil {
+			return w.close(err)
+		}
+
+		file_path := filepath.Join(a.job.Path, filepath.FromSlash(rel_path))
+
+		info, err := os.Lstat(file_path)
+		if err != nil {
+			//file was removed after scanning
+			a.job.Log("Error reading %s: %s", file_path, err.Error())
+			w.skipped++
+			continue
+		}
+
+		if err = w.add(file_path, rel_path, info, nil); err != nil {
+			return w.close(err)
+		}
+	}
+
+	return w.close(nil)
+}
+
+// Packs job directory to archive. If filter is given only files it returns true for are packed.
+// Returns count of packed files (directories are not counted).
+func (a *tarZstdArchiver) create(archive_path string, filter func(header *tar.Header) bool) (int, error) {
+	w, err := a.openWriter(archive_path)
+	if err != nil {
+		return 0, err
+	}
+
+	err = filepath.WalkDir(a.job.Path, func(file_path string, d fs.DirEntry, err error) error {
+		if ctx_err := a.job.context().Err(); ctx_err != nil {
+			return ctx_err
+		}
+
+		if err != nil {
+			//unreadable entries are reported but do not stop archiving
+			a.job.Log("Error reading %s: %s", file_path, err.Error())
+			w.skipped++
+			return nil
+		}
+
+		if file_path == a.job.Path {
+			return nil
+		}
+
+		rel_path, err := filepath.Rel(a.job.Path, file_path)
+		if err != nil {
+			return err
+		}
+		rel_path = filepath.ToSlash(rel_path)
+
+		if matchExcludePatterns(a.job.Settings.Exclude, rel_path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			a.job.Log("Error reading %s: %s", file_path, err.Error())
+			w.skipped++
+			return nil
+		}
+
+		return w.add(file_path, rel_path, info, filter)
+	})
+
+	return w.count, w.close(err)
+}
+
+// tar.zst archive being written
+type tarZstdWriter struct {
+	a       *tarZstdArchiver
+	f       *os.File
+	zw      *zstd.Encoder
+	tw      *tar.Writer
+	count     int //packed files count
+	skipped   int //files not packed because of read errors
+	truncated int //files truncated while being packed (padded with zeroes in archive)
+}
+
+func (a *tarZstdArchiver) openWriter(archive_path string) (*tarZstdWriter, error) {
+	js := &a.job.Settings //convenience variable
+
+	f, err := os.OpenFile(archive_path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []zstd.EOption{
+		zstd.WithEncoderLevel(tarZstdLevel(js.CompressionLevel)),
+	}
+
+	if js.MultithreadCompressionMode == "off" {
+		options = append(options, zstd.WithEncoderConcurrency(1))
+	}
+
+	zw, err := zstd.NewWriter(f, options...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	a.packed = make(map[string]PackedFile)
+
+	return &tarZstdWriter{a: a, f: f, zw: zw, tw: tar.NewWriter(zw)}, nil
+}
+
+// PackedFiles returns files packed to last created archive with their modes and hashes.
+func (a *tarZstdArchiver) PackedFiles() map[string]PackedFile {
+	return a.packed
+}
+
+// adds file or directory to archive. Directories are always added, files only if filter allows it.
+func (w *tarZstdWriter) add(file_path string, rel_path string, info fs.FileInfo, filter func(header *tar.Header) bool) error {
+	var link string
+	var err error
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(file_path); err != nil {
+			w.a.job.Log("Error reading link %s: %s", file_path, err.Error())
+			w.skipped++
+			return nil
+		}
+	} else if !info.Mode().IsRegular() && !info.IsDir() {
+		w.a.job.Log("Skipping special file: %s", file_path)
+		return nil
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+
+	header.Name = rel_path
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if filter != nil && !info.IsDir() && !filter(header) {
+		return nil
+	}
+
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	packed := PackedFile{Mode: info.Mode()}
+
+	if info.Mode().IsRegular() {
+		hash := sha256.New()
+
+		complete, err := tarCopyFile(io.MultiWriter(w.tw, hash), file_path, header.Size)
+		if err != nil {
+			return err
+		}
+
+		//packed content is damaged, so file is not described in manifest
+		if !complete {
+			w.a.job.Log("File was truncated while being packed and is not packed completely: %s", file_path)
+			w.truncated++
+			return nil
+		}
+
+		packed.Sha256 = hex.EncodeToString(hash.Sum(nil))
+
+		w.count++
+	}
+
+	if !info.IsDir() {
+		w.a.packed[rel_path] = packed
+	}
+
+	return nil
+}
+
+// finishes archive. If err is given it is returned as is (with archive closed).
+// *Warning is returned if some files were skipped or truncated.
+func (w *tarZstdWriter) close(err error) error {
+	if err == nil {
+		err = w.tw.Close()
+	}
+
+	if close_err := w.zw.Close(); err == nil {
+		err = close_err
+	}
+
+	if close_err := w.f.Close(); err == nil {
+		err = close_err
+	}
+
+	if err == nil {
+		w.a.job.Log("Files packed: %d", w.count)
+
+		var warnings []error
+
+		if w.skipped > 0 {
+			warnings = append(warnings, fmt.Errorf("%d files could not be read and were not packed", w.skipped))
+		}
+
+		if w.truncated > 0 {
+			warnings = append(warnings, fmt.Errorf("%d files were truncated while being packed and are not packed completely", w.truncated))
+		}
+
+		if len(warnings) > 0 {
+			err = &Warning{Err: errors.Join(warnings...)}
+		}
+	}
+
+	return err
+}
+
+// reads all entries headers from archive
+func (a *tarZstdArchiver) readHeaders(archive_path string) (map[string]*tar.Header, error) {
+	f, err := os.Open(archive_path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	headers := make(map[string]*tar.Header)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", filepath.Base(archive_path), err)
+		}
+
+		//read content to make sure it is not damaged
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", filepath.Base(archive_path), err)
+		}
+
+		headers[header.Name] = header
+	}
+
+	return headers, nil
+}
+
+// copies exactly size bytes from file to archive (file can grow while being packed). Returns false if
+// file was truncated while being packed: it is padded with zeroes to keep tar stream valid then.
+func tarCopyFile(w io.Writer, file_path string, size int64) (bool, error) {
+	f, err := os.Open(file_path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	n, err := io.CopyN(w, f, size)
+
+	if err == io.EOF {
+		_, err = io.CopyN(w, zeroReader{}, size-n)
+		return false, err
+	}
+
+	return true, err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// maps 7-zip like compression level (0-9) to zstd encoder level
+func tarZstdLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level <= 2:
+		return zstd.SpeedFastest
+	case level <= 5:
+		return zstd.SpeedDefault
+	case level <= 8:
+		return zstd.SpeedBetterCompression
+	default:
+		return zstd.SpeedBestCompression
+	}
+}
+
+// sets permissions, ownership and modification time for unpacked entry
+func tarRestoreAttributes(target string, header *tar.Header) {
+	//ownership can be restored by privileged user only, so errors are ignored
+	if runtime.GOOS != "windows" {
+		os.Lchown(target, header.Uid, header.Gid)
+	}
+
+	if header.Typeflag == tar.TypeSymlink {
+		return
+	}
+
+	os.Chmod(target, header.FileInfo().Mode().Perm())
+	os.Chtimes(target, header.ModTime, header.ModTime)
+}
@@ -0,0 +1,286 @@
+package app
+
+import (
+	"archive/tar"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestTarZstdBackupAndRestore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on windows")
+	}
+
+	job := newTestJob(t, func(js *JobSettings) {
+		testDateFormat(js)
+		js.Exclude = []string{"*.tmp"}
+	})
+
+	files := map[string]string{
+		"a.txt":         "first file",
+		"dir/b.txt":     "second file",
+		"dir/sub/c.txt": "third file",
+		"empty/":        "",
+		"link":          "-> a.txt",
+		"skip.tmp":      "excluded",
+	}
+	writeTestFiles(t, job.Path, files)
+
+	mod_time := time.Date(2024, 5, 1, 10, 20, 30, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(job.Path, "dir", "b.txt"), mod_time, mod_time); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(filepath.Join(job.Path, "dir", "b.txt"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	//full archive
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	//changes for diff archive
+	writeTestFiles(t, job.Path, map[string]string{
+		"a.txt":     "first file changed",
+		"new/d.txt": "new file",
+	})
+
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(job.Archive.FullItemList) != 1 || len(job.Archive.FullItemList[0].DiffItemList) != 1 {
+		t.Fatalf("one full and one diff archive expected, found: %d", len(job.Archive.FilesList))
+	}
+
+	//diff contains changed files only
+	list, err := job.Archiver.List(job.Archive.LastFile().Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range list {
+		if entry.Path == "dir/b.txt" || entry.Path == "link" {
+			t.Errorf("unchanged file %s packed to diff archive", entry.Path)
+		}
+	}
+
+	to := filepath.Join(t.TempDir(), "restored")
+	if _, err := job.Restore(to, job.Archive.LastFile(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := readTestTree(t, job.Path)
+	delete(expected, "skip.tmp")
+
+	if got := readTestTree(t, to); !maps.Equal(got, expected) {
+		t.Errorf("restored tree differs:\n%v\nexpected:\n%v", got, expected)
+	}
+
+	info, err := os.Stat(filepath.Join(to, "dir", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("permissions are not restored: %v", info.Mode().Perm())
+	}
+
+	if !info.ModTime().Equal(mod_time) {
+		t.Errorf("modification time is not restored: %v", info.ModTime())
+	}
+}
+
+func TestMatchExcludePatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		path     string
+		expected bool
+	}{
+		{[]string{"*.tmp"}, "file.tmp", true},
+		{[]string{"*.tmp"}, "dir/sub/file.tmp", true},
+		{[]string{"*.tmp"}, "file.txt", false},
+		{[]string{"cache"}, "dir/cache", true},
+		{[]string{"dir/cache"}, "dir/cache", true},
+		{[]string{"./dir/cache"}, "dir/cache", true},
+		{[]string{"dir/cache"}, "other/dir/cache", false},
+		{[]string{"dir/*"}, "dir/file.txt", true},
+		{nil, "file.txt", false},
+	}
+
+	for _, test := range tests {
+		if got := matchExcludePatterns(test.patterns, test.path); got != test.expected {
+			t.Errorf("matchExcludePatterns(%v, %s) = %v", test.patterns, test.path, got)
+		}
+	}
+}
+
+// writes tar.zst archive with given entries (content is written for regular files only)
+func writeTestTarZst(t *testing.T, archive_path string, headers []*tar.Header) {
+	t.Helper()
+
+	f, err := os.Create(archive_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tw := tar.NewWriter(zw)
+
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(header.Name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTarZstdExtractSymlinks(t *testing.T) {
+	job := newTestJob(t, nil)
+	outside := t.TempDir()
+
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+		fails   bool
+	}{
+		{
+			"write through symlinked directory",
+			[]*tar.Header{
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+				{Name: "link/evil.txt", Typeflag: tar.TypeReg, Mode: 0666},
+			},
+			true,
+		},
+		{
+			"replace symlink with file",
+			[]*tar.Header{
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: filepath.Join(outside, "evil.txt"), Mode: 0777},
+				{Name: "link", Typeflag: tar.TypeReg, Mode: 0666},
+			},
+			false,
+		},
+		{
+			"replace symlink with directory",
+			[]*tar.Header{
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+				{Name: "link/", Typeflag: tar.TypeDir, Mode: 0777},
+				{Name: "link/evil.txt", Typeflag: tar.TypeReg, Mode: 0666},
+			},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		archive_path := filepath.Join(t.TempDir(), "test.tar.zst")
+		writeTestTarZst(t, archive_path, test.headers)
+
+		to := t.TempDir()
+		err := job.Archiver.Extract(archive_path, to, true, nil)
+
+		if test.fails && err == nil {
+			t.Errorf("%s: unpacked without error", test.name)
+		} else if !test.fails && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+
+		if _, err := os.Lstat(filepath.Join(outside, "evil.txt")); err == nil {
+			t.Fatalf("%s: file written outside destination directory", test.name)
+		}
+	}
+}
+
+func TestTarZstdTruncatedFile(t *testing.T) {
+	job := newTestJob(t, nil)
+
+	writeTestFiles(t, job.Path, map[string]string{"shrinking.txt": "0123456789", "ok.txt": "packed"})
+
+	shrinking_path := filepath.Join(job.Path, "shrinking.txt")
+	ok_path := filepath.Join(job.Path, "ok.txt")
+
+	shrinking_info, err := os.Lstat(shrinking_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok_info, err := os.Lstat(ok_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//file is truncated after it was scanned but before its content is packed
+	if err := os.Truncate(shrinking_path, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	archiver := job.Archiver.(*tarZstdArchiver)
+	archive_path := filepath.Join(job.Settings.ArchivesPath, "test.tar.zst")
+
+	w, err := archiver.openWriter(archive_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.add(shrinking_path, "shrinking.txt", shrinking_info, nil); err != nil {
+		t.Fatal(w.close(err))
+	}
+
+	if err := w.add(ok_path, "ok.txt", ok_info, nil); err != nil {
+		t.Fatal(w.close(err))
+	}
+
+	if err := w.close(nil); !IsWarning(err) {
+		t.Errorf("warning expected for truncated file, got: %v", err)
+	}
+
+	//archive stays readable
+	if err := archiver.Test(archive_path); err != nil {
+		t.Fatalf("archive is damaged: %s", err)
+	}
+
+	if err := job.writeManifest(job.Archiver, archive_path, nil, nil, sourceDirs{}); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := LoadManifest(archive_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Files) != 1 || manifest.Files[0].Path != "ok.txt" {
+		t.Errorf("truncated file is described in manifest: %+v", manifest.Files)
+	}
+}
@@ -0,0 +1,197 @@
+package app
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNewArchiver(t *testing.T) {
+	if !slices.Contains(ArchiverNames(), DefaultArchiver) {
+		t.Fatalf("default archiver %s is not registered: %v", DefaultArchiver, ArchiverNames())
+	}
+
+	job := &Job{}
+	job.Settings.Archiver = DefaultArchiver
+
+	archiver, err := newArchiver(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if archiver.Extension() != ".7z" {
+		t.Errorf("unexpected extension for %s archiver: %s", DefaultArchiver, archiver.Extension())
+	}
+
+	job.Settings.Archiver = "unknown"
+	if _, err := newArchiver(job); err == nil {
+		t.Error("no error for unknown archiver")
+	}
+}
+
+func TestSevenZipArguments(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings JobSettings
+		expected []string
+	}{
+		{
+			name:     "defaults",
+			settings: JobSettings{},
+			expected: []string{"a", "test.7z", "-t7z", "-r0", "-ssw", "-bb1", "-bse1", "-sccUTF-8"},
+		},
+		{
+			name:     "solid and multithread",
+			settings: JobSettings{Solid: true, MultithreadCompressionMode: "4"},
+			expected: []string{"a", "test.7z", "-t7z", "-r0", "-ssw", "-bb1", "-bse1", "-sccUTF-8", "-ms=on", "-mmt=4"},
+		},
+		{
+			name:     "password",
+			settings: JobSettings{Password: "secret"},
+			expected: []string{"a", "test.7z", "-t7z", "-r0", "-ssw", "-bb1", "-bse1", "-sccUTF-8", "-psecret"},
+		},
+		{
+			name:     "encrypted filenames",
+			settings: JobSettings{Password: "secret", EncryptFilenames: true},
+			expected: []string{"a", "test.7z", "-t7z", "-r0", "-ssw", "-bb1", "-bse1", "-sccUTF-8", "-psecret", "-mhe"},
+		},
+		{
+			name:     "filenames are not encrypted without password",
+			settings: JobSettings{EncryptFilenames: true},
+			expected: []string{"a", "test.7z", "-t7z", "-r0", "-ssw", "-bb1", "-bse1", "-sccUTF-8"},
+		},
+		{
+			name:     "exclude",
+			settings: JobSettings{Exclude: []string{"*.tmp", "cache"}},
+			expected: []string{"a", "test.7z", "-t7z", "-r0", "-ssw", "-bb1", "-bse1", "-sccUTF-8", "-xr!*.tmp", "-xr!cache"},
+		},
+	}
+
+	for _, test := range tests {
+		job := &Job{Settings: test.settings}
+		archiver := newSevenZipArchiver(job).(*sevenZipArchiver)
+
+		if got := archiver.commonArguments("a", "test.7z"); !slices.Equal(got, test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestArchiverForFile(t *testing.T) {
+	job := &Job{}
+	job.Settings.Archiver = DefaultArchiver
+
+	tests := []struct {
+		path      string
+		extension string //"" = no archiver expected
+	}{
+		{"/backups/test_2024-01-01_FULL.7z", ".7z"},
+		{"/backups/test_2024-01-01_FULL.tar.zst", ".tar.zst"},
+		{"/backups/test_2024-01-01_FULL.zip", ""},
+	}
+
+	for _, test := range tests {
+		archiver, err := job.archiverForFile(test.path)
+
+		if test.extension == "" {
+			if err == nil {
+				t.Errorf("%s: archiver found for unknown extension", test.path)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+		} else if archiver.Extension() != test.extension {
+			t.Errorf("%s: archiver for %s chosen", test.path, archiver.Extension())
+		}
+	}
+}
+
+func TestEntryTargetPath(t *testing.T) {
+	to := filepath.FromSlash("/restore")
+
+	tests := []struct {
+		name     string
+		expected string //"" = error expected
+	}{
+		{"file.txt", "/restore/file.txt"},
+		{"dir/sub/", "/restore/dir/sub"},
+		{"../../etc/passwd", "/restore/etc/passwd"},
+		{"/abs/path", "/restore/abs/path"},
+		{"./", ""},
+	}
+
+	for _, test := range tests {
+		got, err := entryTargetPath(to, test.name)
+
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil || got != filepath.FromSlash(test.expected) {
+			t.Errorf("%s: got %s, %v", test.name, got, err)
+		}
+	}
+}
+
+func TestMatchPathPatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		path     string
+		expected bool
+	}{
+		{nil, "any/file.txt", true},
+		{[]string{"file.txt"}, "file.txt", true},
+		{[]string{"file.txt"}, "dir/file.txt", false},
+		{[]string{"dir"}, "dir/sub/file.txt", true},
+		{[]string{"dir/"}, "dir/file.txt", true},
+		{[]string{"./dir/sub"}, "dir/sub/file.txt", true},
+		{[]string{"dir/sub"}, "dir/other.txt", false},
+		{[]string{"dir/*.txt"}, "dir/file.txt", true},
+		{[]string{"dir/*.txt"}, "dir/file.log", false},
+		{[]string{"other", "dir"}, "dir/file.txt", true},
+	}
+
+	for _, test := range tests {
+		if got := MatchPathPatterns(test.patterns, test.path); got != test.expected {
+			t.Errorf("MatchPathPatterns(%v, %s) = %v", test.patterns, test.path, got)
+		}
+	}
+}
+
+func TestSevenZipCommandLines(t *testing.T) {
+	job := &Job{Path: "/src", Settings: JobSettings{Password: "secret"}, Options: JobOptions{SevenZipCmd: "7z"}}
+	archiver := newSevenZipArchiver(job).(*sevenZipArchiver)
+
+	tests := []struct {
+		name      string
+		base_path string
+		from_list bool
+		contains  string
+	}{
+		{"full", "", false, "7z a test.7z"},
+		{"diff", "full.7z", false, "7z u full.7z"},
+		{"incremental", "full.7z", true, "@<list of changed files>"},
+	}
+
+	for _, test := range tests {
+		lines := archiver.CommandLines("test.7z", test.base_path, test.from_list)
+
+		if len(lines) == 0 || !strings.Contains(lines[0], test.contains) {
+			t.Errorf("%s: %v does not contain %s", test.name, lines, test.contains)
+		}
+
+		for _, line := range lines {
+			if strings.Contains(line, "secret") || !strings.Contains(line, "-p***") {
+				t.Errorf("%s: password is not masked: %s", test.name, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mitoteam/mttools"
+)
+
+// Process exit codes
+const (
+	ExitOk              = 0 //everything is fine
+	ExitError           = 1 //general error: wrong arguments, settings, i/o errors etc.
+	ExitWarning         = 2 //job is done, but with warnings (some files were not archived for example)
+	ExitArchiverFailed  = 3 //archiver failed or created archive is broken
+	ExitRunBeforeFailed = 4 //'run_before' command failed and 'run_before_failure: abort' is set
+	ExitInterrupted     = 5 //job was interrupted (SIGINT, SIGTERM or canceled context)
+)
+
+// Warning is returned when job was done, but something went wrong (7-Zip exit code 1 for example:
+// archive was created, but some files were not added to it).
+type Warning struct {
+	Err error
+}
+
+func (e *Warning) Error() string {
+	return "warning: " + e.Err.Error()
+}
+
+func (e *Warning) Unwrap() error {
+	return e.Err
+}
+
+// ArchiverError is returned when archiver failed to create archive.
+type ArchiverError struct {
+	Archive string //archive filename
+	Err     error
+}
+
+func (e *ArchiverError) Error() string {
+	return fmt.Sprintf("error creating archive %s: %s", e.Archive, e.Err.Error())
+}
+
+func (e *ArchiverError) Unwrap() error {
+	return e.Err
+}
+
+// CommandError is returned when one of 'run_before' commands failed.
+type CommandError struct {
+	Command string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command \"%s\" failed: %s", e.Command, e.Err.Error())
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// SettingsError is returned when settings can not be loaded or have wrong values.
+type SettingsError struct {
+	Filename string //settings file (empty if error is not related to file)
+	Err      error
+}
+
+func (e *SettingsError) Error() string {
+	if e.Filename != "" {
+		return fmt.Sprintf("settings error (%s): %s", e.Filename, e.Err.Error())
+	}
+
+	return "settings error: " + e.Err.Error()
+}
+
+func (e *SettingsError) Unwrap() error {
+	return e.Err
+}
+
+// settings validation error
+func newSettingsError(format string, args ...any) error {
+	return &SettingsError{Err: fmt.Errorf(format, args...)}
+}
+
+// ScanError is returned when archives directory can not be scanned.
+type ScanError struct {
+	Path string //file or directory being read
+	Err  error
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("error scanning archives (%s): %s", e.Path, e.Err.Error())
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// DeleteError is returned when archive (or other file) can not be deleted.
+type DeleteError struct {
+	Path string
+	Err  error
+}
+
+func (e *DeleteError) Error() string {
+	return fmt.Sprintf("error deleting file %s: %s", e.Path, e.Err.Error())
+}
+
+func (e *DeleteError) Unwrap() error {
+	return e.Err
+}
+
+// LogError is returned when log file can not be prepared.
+type LogError struct {
+	Path string //log file
+	Err  error
+}
+
+func (e *LogError) Error() string {
+	return fmt.Sprintf("log file error (%s): %s", e.Path, e.Err.Error())
+}
+
+func (e *LogError) Unwrap() error {
+	return e.Err
+}
+
+// SpaceError is returned when new archive does not fit into 'max_archives_total_size' or
+// 'min_free_space' limits even after old archives are deleted.
+type SpaceError struct {
+	Required int64  //estimated new archive size
+	Reason   string //limit that can not be met
+}
+
+func (e *SpaceError) Error() string {
+	return fmt.Sprintf("not enough space for new archive (about %s): %s", mttools.FormatFileSize(e.Required), e.Reason)
+}
+
+// LockError is returned when archives directory is locked by another process.
+type LockError struct {
+	Path   string      //lock file
+	Holder JobLockInfo //process holding lock
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf("archives directory is locked by another process: %s (lock file: %s)", e.Holder.String(), e.Path)
+}
+
+// IsWarning checks if err is warning only (job was done anyway).
+func IsWarning(err error) bool {
+	var warning *Warning
+
+	return errors.As(err, &warning)
+}
+
+// ExitCode returns process exit code for error returned by command.
+func ExitCode(err error) int {
+	var archiver_error *ArchiverError
+	var command_error *CommandError
+
+	switch {
+	case err == nil:
+		return ExitOk
+
+	//warnings go first: they can wrap errors of other types
+	case IsWarning(err):
+		return ExitWarning
+
+	//interrupted archiver returns ArchiverError, so this goes first
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+
+	case errors.As(err, &archiver_error):
+		return ExitArchiverFailed
+
+	case errors.As(err, &command_error):
+		return ExitRunBeforeFailed
+
+	default:
+		return ExitError
+	}
+}
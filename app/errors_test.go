@@ -0,0 +1,35 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	archiver_error := &ArchiverError{Archive: "test.7z", Err: errors.New("disk full")}
+	command_error := &CommandError{Command: "false", Err: errors.New("exit status 1")}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"no error", nil, ExitOk},
+		{"error", errors.New("something failed"), ExitError},
+		{"warning", &Warning{Err: errors.New("file skipped")}, ExitWarning},
+		{"archiver error", archiver_error, ExitArchiverFailed},
+		{"wrapped archiver error", fmt.Errorf("run: %w", archiver_error), ExitArchiverFailed},
+		{"command error", command_error, ExitRunBeforeFailed},
+		{"command warning", &Warning{Err: command_error}, ExitWarning},
+		{"interrupted", context.Canceled, ExitInterrupted},
+		{"interrupted archiver", &ArchiverError{Archive: "test.7z", Err: context.Canceled}, ExitInterrupted},
+	}
+
+	for _, test := range tests {
+		if got := ExitCode(test.err); got != test.expected {
+			t.Errorf("%s: exit code %d, expected %d", test.name, got, test.expected)
+		}
+	}
+}
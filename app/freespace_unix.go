@@ -0,0 +1,16 @@
+//go:build !windows
+
+package app
+
+import "syscall"
+
+// diskFreeSpace returns space available to user on filesystem path belongs to.
+func diskFreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
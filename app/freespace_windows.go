@@ -0,0 +1,27 @@
+//go:build windows
+
+package app
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskFreeSpace returns space available to user on disk path belongs to.
+func diskFreeSpace(path string) (int64, error) {
+	path_ptr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var free_bytes uint64
+
+	result, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path_ptr)), uintptr(unsafe.Pointer(&free_bytes)), 0, 0)
+	if result == 0 {
+		return 0, err
+	}
+
+	return int64(free_bytes), nil
+}
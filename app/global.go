@@ -4,28 +4,23 @@ package app
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
-
-	"github.com/mitoteam/mttools"
-	"github.com/spf13/cobra"
 )
 
 var BuildVersion = "DEV"
 var BuildCommit = "DEV"
 
+// Application information (does not change while program is running)
 var Global struct {
-	AppName      string
-	Version      string
-	AppWebsite   string
-	Commit       string
-	BuiltWith    string
-	SevenZipCmd  string
-	SevenZipInfo string
-	License      string
+	AppName    string
+	Version    string
+	AppWebsite string
+	Commit     string
+	BuiltWith  string
+	License    string
 }
 
 func init() {
@@ -36,70 +31,61 @@ func init() {
 	Global.BuiltWith = runtime.Version()
 }
 
-func SetupBeforeCommand(cmd *cobra.Command, args []string) error {
-	if JobRuntimeOptions.NoConsole {
-		if mttools.IsWindows() {
-			mttools.HideConsole()
-		} else {
-			fmt.Println("--no-console option ignored under Linux")
-		}
-	}
-
-	if Global.SevenZipCmd == "auto" {
-		Global.SevenZipCmd = "" //reset to force autodetection
-	}
+// SevenZip describes 7-Zip executable.
+type SevenZip struct {
+	Cmd  string // command to run 7-Zip (empty = not found)
+	Info string // first line of 7-Zip output (version and copyright)
+}
 
-	if len(Global.SevenZipCmd) > 0 {
-		if !checkSevenZipCommand(Global.SevenZipCmd) {
-			return errors.New("Can not run provided 7-Zip command: " + Global.SevenZipCmd)
+// DetectSevenZip checks given 7-Zip command. If cmd is empty or "auto" it tries to find 7-Zip
+// in well-known places. 7-Zip not found is not an error: native archivers do not require it
+// (empty SevenZip is returned then). Error is returned only if explicitly given command does not work.
+func DetectSevenZip(cmd string) (SevenZip, error) {
+	if cmd != "" && cmd != "auto" {
+		if sz, ok := checkSevenZipCommand(cmd); ok {
+			return sz, nil
 		}
-	} else {
-		//try autodetect
-		//try raw 7z command
-		r := checkSevenZipCommand("7z")
 
-		if !r {
-			switch runtime.GOOS {
-			case "windows":
-				r = checkSevenZipCommand(os.Getenv("ProgramFiles") + "\\7-Zip\\7z.exe")
+		return SevenZip{}, errors.New("Can not run provided 7-Zip command: " + cmd)
+	}
 
-				if !r {
-					r = checkSevenZipCommand(os.Getenv("ProgramFiles(x86)") + "\\7-Zip\\7z.exe")
-				}
-			case "linux":
-				r = checkSevenZipCommand("/usr/lib/p7zip/7z")
-				if !r {
-					r = checkSevenZipCommand("/usr/bin/7z")
-				}
-				if !r {
-					r = checkSevenZipCommand("/bin/7z")
-				}
-			}
-		}
+	//try autodetect
+	//try raw 7z command
+	candidates := []string{"7z"}
+
+	switch runtime.GOOS {
+	case "windows":
+		candidates = append(candidates,
+			os.Getenv("ProgramFiles")+"\\7-Zip\\7z.exe",
+			os.Getenv("ProgramFiles(x86)")+"\\7-Zip\\7z.exe",
+		)
+	case "linux":
+		candidates = append(candidates, "/usr/lib/p7zip/7z", "/usr/bin/7z", "/bin/7z")
+	}
 
-		if !r {
-			return errors.New("Can not find 7-Zip. Please provide correct path with --7zip flag.")
+	for _, candidate := range candidates {
+		if sz, ok := checkSevenZipCommand(candidate); ok {
+			return sz, nil
 		}
 	}
 
-	return nil
+	//not fatal: 7-Zip is not required by native archivers, 7z archiver checks it by itself
+	return SevenZip{}, nil
 }
 
-func checkSevenZipCommand(cmd string) bool {
+func checkSevenZipCommand(cmd string) (SevenZip, bool) {
 	out, err := exec.Command(cmd).Output()
 
 	if err != nil {
-		return false
+		return SevenZip{}, false
 	}
 
 	scanner := bufio.NewScanner(strings.NewReader(string(out)))
 	for scanner.Scan() {
 		if scanner.Text() != "" {
-			Global.SevenZipInfo = scanner.Text()
-			Global.SevenZipCmd = cmd
-			return true
+			return SevenZip{Cmd: cmd, Info: scanner.Text()}, true
 		}
 	}
 
-	return false
+	return SevenZip{}, false
 }
@@ -1,12 +1,15 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
 	"path/filepath"
-	"strconv"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -17,290 +20,509 @@ type Job struct {
 	Path     string
 	Settings JobSettings
 	Archive  JobArchive
+	Archiver Archiver // archiving engine selected by 'archiver' setting
 
+	Options JobOptions // runtime options
+
+	ctx     context.Context
 	logger  *slog.Logger
 	logfile *os.File
+
+	manifestKey *manifestKey // manifests encryption key derived from password (see getManifestKey)
+
+	dryRunDeleted map[string]bool // dry run: archives that would be deleted (ScanArchive skips them)
 }
 
-// Creates new Job. If first argument given - using it as path to directory. If absent - using current directory.
-func NewJobFromArgs(args []string) (*Job, error) {
-	var path string
+// NewJob creates new Job for directory. Settings are loaded from settings file (if it exists) and
+// overridden by runtime options.
+func NewJob(path string, options JobOptions) (*Job, error) {
+	path, err := mttools.GetDirAbsolutePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var job = &Job{
+		Path:    path,
+		Options: options,
+	}
 
-	if len(args) > 0 {
-		path = args[0]
-	} else {
-		path = "." //current directory
+	if job.Options.SettingsFilename == "" {
+		job.Options.SettingsFilename = DefaultSettingsFilename
 	}
 
-	path, err := mttools.GetDirAbsolutePath(path)
+	if err := job.LoadSettings(); err != nil {
+		return nil, err
+	}
+
+	if err := job.prepare(); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Creates new Job using settings copy stored in archives directory by 'run' command. Source directory
+// is not required at all. options.SettingsFilename can be used to choose settings copy file explicitly.
+func NewJobFromArchives(archives_path string, options JobOptions) (*Job, error) {
+	archives_path, err := mttools.GetDirAbsolutePath(archives_path)
 	if err != nil {
 		return nil, err
 	}
 
+	var filename string
+
+	if options.SettingsFilename != "" && options.SettingsFilename != DefaultSettingsFilename {
+		filename = options.SettingsFilename
+
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(archives_path, filename)
+		}
+	} else {
+		list, err := filepath.Glob(filepath.Join(archives_path, SettingsCopyPrefix+"*.yml"))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(list) == 0 {
+			return nil, fmt.Errorf("no settings copy found in %s", archives_path)
+		}
+
+		if len(list) > 1 {
+			return nil, fmt.Errorf(
+				"several settings copies found in %s, use --settings to choose one of: %s",
+				archives_path, strings.Join(list, ", "),
+			)
+		}
+
+		filename = list[0]
+	}
+
 	var job = &Job{
-		Path: path,
+		Path:    archives_path, //there is no source directory, so archives one is used
+		Options: options,
 	}
 
-	job.LoadSettings()
+	job.Settings = NewJobSettings()
+
+	if err := job.Settings.LoadFromFile(filename); err != nil {
+		return nil, err
+	}
+
+	//archives directory could be moved since settings were saved
+	job.Settings.ArchivesPath = archives_path
+
+	if err := job.Settings.ApplyDefaultsAndCheck(job.Path, job.Options); err != nil {
+		return nil, err
+	}
+
+	if err := job.prepare(); err != nil {
+		return nil, err
+	}
+
+	job.Log("Settings loaded from %s", filename)
+
+	return job, nil
+}
+
+// prepares job to work after settings are loaded: archiver, archives directory, logger
+func (job *Job) prepare() (err error) {
+	if job.Archiver, err = newArchiver(job); err != nil {
+		return err
+	}
 
 	// make sure archives directory exists
-	if !mttools.IsDirExists(job.Settings.ArchivesPath) {
+	if !mttools.IsDirExists(job.Settings.ArchivesPath) && job.Options.DryRun {
+		job.Log("Dry run: archives directory would be created: %s", job.Settings.ArchivesPath)
+	} else if !mttools.IsDirExists(job.Settings.ArchivesPath) {
 		if err := os.MkdirAll(job.Settings.ArchivesPath, 0777); err != nil {
-			return nil, err
+			return err
 		}
 
 		job.Log("Archives directory created: %s", job.Settings.ArchivesPath)
 	}
 
-	//initialize logger
+	//initialize logger (read-only archives directory can still be restored from, so log goes to screen only then)
 	if job.Settings.LogFormat == "text" || job.Settings.LogFormat == "json" {
-		job.prepareLogger()
+		if err := job.prepareLogger(); err != nil {
+			if !isReadOnlyDir(job.Settings.ArchivesPath) {
+				return err
+			}
+
+			job.Log("Archives directory is read-only, log file is not written: %s", err.Error())
+		}
 	}
 
-	return job, nil
+	return nil
+}
+
+// checks if directory exists and files can not be created in it (mounted read-only or no permissions)
+func isReadOnlyDir(path string) bool {
+	if !mttools.IsDirExists(path) {
+		return false
+	}
+
+	f, err := os.CreateTemp(path, ".mtsaver-write-test-*")
+	if err != nil {
+		return true
+	}
+
+	f.Close()
+	os.Remove(f.Name())
+
+	return false
 }
 
-func (job *Job) Run() error {
+// SetContext sets context for job operations. When it is canceled running archiver is stopped
+// and operation returns error.
+func (job *Job) SetContext(ctx context.Context) {
+	job.ctx = ctx
+}
+
+// job operations context (never nil)
+func (job *Job) context() context.Context {
+	if job.ctx == nil {
+		return context.Background()
+	}
+
+	return job.ctx
+}
+
+// Close closes job log file. Job should not be used after that.
+func (job *Job) Close() error {
+	if job.logfile == nil {
+		return nil
+	}
+
+	err := job.logfile.Close()
+
+	job.logfile = nil
+	job.logger = nil
+
+	return err
+}
+
+// Run creates new archive (full or diff one, according to settings) and cleans up old ones.
+// Returns *Warning if archive was created but something went wrong (see ExitCode).
+func (job *Job) Run() (result JobRunResult, err error) {
 	job.Log("[%s v%s] Starting directory backup: %s", Global.AppName, Global.Version, job.Path)
 
+	if job.Options.DryRun {
+		job.Log("Dry run: nothing is changed on disk")
+	}
+
+	defer func() {
+		if err != nil {
+			if IsWarning(err) {
+				job.Log("Backup finished with warnings: %s", err.Error())
+			} else if errors.Is(err, context.Canceled) {
+				job.Log("Backup interrupted")
+			} else {
+				job.Log("Backup failed: %s", err.Error())
+			}
+		}
+	}()
+
+	// first warning is returned if nothing failed
+	var warning error
+
+	if err = job.Archiver.Check(); err != nil {
+		return result, err
+	}
+
+	//temp files of another process should not be removed as stale ones
+	unlock, err := job.lock("run")
+	if err != nil {
+		return result, err
+	}
+	defer unlock()
+
+	job.removeStaleTempFiles()
+
+	//keep settings with archives to be able to restore them without source directory
+	if job.Options.DryRun {
+		job.Log("Dry run: settings copy would be saved to %s", filepath.Base(job.Settings.SettingsCopyFilename()))
+	} else if err := job.Settings.SaveCopy(job.Path); err != nil {
+		job.Log("Error saving settings copy: %s", err.Error())
+	}
+
 	if job.Settings.Cleanup == "before" {
-		job.Cleanup()
+		cleanup_result, err := job.cleanup(false)
+		result.Deleted = cleanup_result.Deleted
+
+		if err != nil {
+			return result, err
+		}
 	}
 
-	job.ScanArchive(true)
-	//job.Archive.Dump(false)
+	if err = job.ScanArchive(true); err != nil {
+		return result, err
+	}
+	//job.Archive.Dump()
 
 	//run commands before creating new archive
-	if len(job.Settings.RunBefore) > 0 {
-		job.Log("Executing commands from 'run_before' option")
+	if err = job.runBeforeCommands(); err != nil {
+		if !IsWarning(err) {
+			return result, err
+		}
 
-		for _, command := range job.Settings.RunBefore {
-			job.Log("Command: %s", command)
+		warning = err
+	}
 
-			if output, err := mttools.ExecCommandLine(command); err != nil {
-				job.Log("Command error: %s", err.Error())
-			} else {
-				if job.Settings.LogCommandOutput {
-					job.Log("Command output:")
-					job.RawLog(output)
-				} else {
-					//screen only
-					log.Println("Command output:")
-					fmt.Println(output)
-				}
-			}
+	//archive created by this run ("" if none was created)
+	var created string
+
+	//decide which archive to create
+	plan, err := PlanArchive(&job.Archive, &job.Settings, job.Options, job.Archiver.Extension())
+	if err != nil {
+		return result, err
+	}
+
+	job.Log("%s", plan.Reason)
+
+	if job.Options.DryRun || job.Settings.HasSpaceLimits() {
+		if err = job.estimateArchive(&plan); err != nil {
+			return result, err
 		}
 	}
 
-	if JobRuntimeOptions.ForceFull {
-		job.Log("Full archive was forced")
-		job.createArchive(true, "")
-	} else if JobRuntimeOptions.ForceDiff {
-		if len(job.Archive.FullItemList) == 0 {
-			log.Fatalln("Can not force differential backup because no full backups found.")
+	//make room for new archive or refuse to create it
+	if job.Settings.HasSpaceLimits() {
+		deleted, err := job.ensureSpace(&plan)
+		result.Deleted = append(result.Deleted, deleted...)
+
+		if err != nil {
+			return result, err
 		}
+	}
 
-		job.Log("Diff archive was forced")
-		job.createArchive(false, job.Archive.FullItemList[len(job.Archive.FullItemList)-1].File.Path)
-	} else if len(job.Archive.FullItemList) == 0 {
-		//no full archives at all, create one unconditionally
-		job.Log("No full archives found. Creating one.")
-		job.createArchive(true, "")
+	if job.Options.DryRun {
+		result.Planned, err = job.dryRunArchive(plan)
+	} else if plan.Full {
+		created, err = job.createArchive(nil)
 	} else {
-		//check diffs for the last one full-arch
-		need_full := false
-		last_full_arch := job.Archive.FullItemList[len(job.Archive.FullItemList)-1]
-
-		//check max count
-		if len(last_full_arch.DiffItemList) >= job.Settings.MaxDiffCount {
-			job.Log(
-				"Diff archives count (%d) exceeds maximum (%d). Creating full archive.",
-				len(last_full_arch.DiffItemList), job.Settings.MaxDiffCount,
-			)
-			need_full = true
+		created, err = job.createDiffArchive(plan.Base)
+	}
+
+	if err != nil {
+		if !IsWarning(err) {
+			return result, err
+		}
+
+		if warning == nil {
+			warning = err
 		}
+	}
 
-		//check max total size (in percents!)
-		if !need_full && job.Settings.MaxTotalDiffSizePercent > 0 {
-			if last_full_arch.TotalDiffSizePercent >= job.Settings.MaxTotalDiffSizePercent {
-				job.Log(
-					"Diff archives total size (%d%% of full archive) exceeds maximum (%d%%). Creating full archive.",
-					last_full_arch.TotalDiffSizePercent, job.Settings.MaxTotalDiffSizePercent,
-				)
+	if created != "" {
+		result.Created = filepath.Base(created)
 
-				need_full = true
+		//pin before cleanup so it keeps new archive chain
+		if job.Options.Pin {
+			if err = job.pinArchive(created, job.Options.Label); err != nil {
+				return result, err
 			}
 		}
+	} else if job.Options.Pin {
+		if job.Options.DryRun {
+			job.Log("Dry run: archive would be pinned: %s", result.Planned)
+		} else {
+			job.Log("WARNING: Archive was not created, nothing to pin")
+		}
+	}
 
-		//check last diff size (in percents!)
-		if !need_full && job.Settings.MaxDiffSizePercent > 0 && len(last_full_arch.DiffItemList) > 0 {
-			last_diff_item := last_full_arch.DiffItemList[len(last_full_arch.DiffItemList)-1]
-			if last_diff_item.DiffSizePercent >= job.Settings.MaxDiffSizePercent {
-				job.Log(
-					"Last diff archive size (%d%% of full archive) exceeds maximum (%d%%). Creating full archive.",
-					last_diff_item.DiffSizePercent, job.Settings.MaxDiffSizePercent,
-				)
+	if job.Settings.Cleanup == "after" {
+		//never delete old archives if new one is broken
+		if job.Settings.VerifyBeforeCleanup && created != "" && !job.verifyCreatedArchive(created) {
+			job.Log("WARNING: Verification of %s failed. Cleanup skipped, old archives are kept.", filepath.Base(created))
 
-				need_full = true
-			}
+			return result, &ArchiverError{Archive: filepath.Base(created), Err: errors.New("archive verification failed")}
 		}
 
-		if !need_full {
-			job.Log("Creating diff archive for %s", last_full_arch.File.Name)
+		//dry run: new full archive is not really created, but cleanup should count it
+		cleanup_result, err := job.cleanup(job.Options.DryRun && plan.Full)
+		result.Deleted = append(result.Deleted, cleanup_result.Deleted...)
+
+		if err != nil {
+			return result, err
 		}
+	}
+
+	return result, warning
+}
 
-		job.createArchive(need_full, last_full_arch.File.Path)
+// runs commands from 'run_before' option. Failed command aborts run or is reported as warning
+// depending on 'run_before_failure' option.
+func (job *Job) runBeforeCommands() error {
+	if len(job.Settings.RunBefore) == 0 {
+		return nil
 	}
 
-	if job.Settings.Cleanup == "after" {
-		job.Cleanup()
+	if job.Options.SkipRunBefore {
+		job.Log("Commands from 'run_before' option are skipped: %s", strings.Join(job.Settings.RunBefore, "; "))
+		return nil
 	}
 
-	if job.logfile != nil {
-		job.logfile.Close()
+	job.Log("Executing commands from 'run_before' option")
+
+	var warning error
+
+	for _, command := range job.Settings.RunBefore {
+		if err := job.context().Err(); err != nil {
+			return err
+		}
+
+		job.Log("Command: %s", command)
+
+		output, err := mttools.ExecCommandLine(command)
+
+		if err != nil {
+			job.Log("Command error: %s", err.Error())
+
+			command_error := &CommandError{Command: command, Err: err}
+
+			if job.Settings.RunBeforeFailure == "abort" {
+				return command_error
+			}
+
+			if warning == nil {
+				warning = &Warning{Err: command_error}
+			}
+
+			continue
+		}
+
+		if job.Settings.LogCommandOutput {
+			job.Log("Command output:")
+			job.RawLog(output)
+		} else {
+			//screen only
+			log.Println("Command output:")
+			fmt.Println(output)
+		}
 	}
 
-	return nil
+	return warning
 }
 
-func (job *Job) Dump() {
+func (job *Job) Dump() error {
 	if !mttools.IsDirExists(job.Settings.ArchivesPath) {
 		fmt.Printf("%s directory does not exists\n", job.Settings.ArchivesPath)
 	}
 
-	job.ScanArchive(true)
-	job.Archive.Dump(false)
-}
+	if err := job.ScanArchive(true); err != nil {
+		return err
+	}
 
-func (job *Job) getArchiveName(is_full bool) string {
-	var suffix string
+	job.Archive.Dump()
 
-	if is_full {
-		suffix = job.Settings.FullSuffix
-	} else {
-		suffix = job.Settings.DiffSuffix
-	}
+	return nil
+}
 
-	return filepath.Join(
-		job.Settings.ArchivesPath,
-		job.Settings.ArchiveName+"_"+time.Now().Format(job.Settings.DateFormat)+"_"+suffix+".7z",
-	)
+// full path to archive file by its name
+func (job *Job) archivePath(name string) string {
+	return filepath.Join(job.Settings.ArchivesPath, name)
 }
 
 func (job *Job) SettingsFilename() (filename string) {
-	if filepath.IsAbs(JobRuntimeOptions.SettingsFilename) {
-		filename = JobRuntimeOptions.SettingsFilename
+	if filepath.IsAbs(job.Options.SettingsFilename) {
+		filename = job.Options.SettingsFilename
 	} else {
-		filename = filepath.Join(job.Path, JobRuntimeOptions.SettingsFilename)
+		filename = filepath.Join(job.Path, job.Options.SettingsFilename)
 	}
 
 	return
 }
 
-func (job *Job) LoadSettings() {
+func (job *Job) LoadSettings() error {
 	job.Settings = NewJobSettings()
 
 	if mttools.IsFileExists(job.SettingsFilename()) {
 		if err := job.Settings.LoadFromFile(job.SettingsFilename()); err != nil {
-			log.Fatalln(err)
+			return err
 		}
 	}
 
 	var s = &job.Settings
 
 	// set defaults if something is missing in file
-	s.ApplyDefaultsAndCheck(job.Path)
-}
-
-func (job *Job) createArchive(is_full bool, full_archive_path string) {
-	var common_arguments = []string{} //7-zip command (add or update), basic compression settings
-	job_archive_filename := job.getArchiveName(is_full)
-	var err error
-	start_time := time.Now()
-	js := &job.Settings //convenience variable
-
-	if is_full {
-		common_arguments = append(common_arguments, "a", job_archive_filename)
-	} else {
-		// thanks: https://nagimov.me/post/simple-differential-and-incremental-backups-using-7-zip/
+	if err := s.ApplyDefaultsAndCheck(job.Path, job.Options); err != nil {
+		if settings_error, ok := err.(*SettingsError); ok && s.LoadedFromFile {
+			settings_error.Filename = job.SettingsFilename()
+		}
 
-		common_arguments = append(common_arguments,
-			"u",
-			full_archive_path, //existing full archive
-			"-u-",             // disable updates in the base archive
-			"-up3q3r2x2y2z0w2!"+job_archive_filename,
-		)
+		return err
 	}
 
-	common_arguments = append(common_arguments,
-		"-r0",       //recursion only for patterns with wildcard
-		"-ssw",      //compress files open for writing
-		"-bb1",      //show names of processed files
-		"-bse1",     //error messages to stdout
-		"-sccUTF-8", //console output encoding
-	)
-
-	//turn on solid mode for archives
-	if js.Solid {
-		common_arguments = append(common_arguments, "-ms=on")
-	}
+	return nil
+}
 
-	//set Multithread Mode
-	if js.MultithreadCompressionMode != "" {
-		common_arguments = append(common_arguments, "-mmt="+js.MultithreadCompressionMode)
+// creates diff or incremental archive (depending on 'mode' setting) for full item.
+// Returns created archive path or "" if archive was not created.
+func (job *Job) createDiffArchive(full_item *JobArchiveFullItem) (string, error) {
+	if job.Settings.Mode == "incremental" {
+		return job.createIncrementalArchive(full_item)
+	} else {
+		return job.createArchive(full_item)
 	}
+}
 
-	//set password for archive
-	if len(js.Password) > 0 {
-		common_arguments = append(common_arguments, "-p"+js.Password)
+// creates full archive (full_item is nil) or differential one for full_item.
+// Returns created archive path or "" if archive was not created (or was removed as empty or same one).
+// *Warning is returned along with archive path if archiver reported warnings.
+func (job *Job) createArchive(full_item *JobArchiveFullItem) (string, error) {
+	is_full := full_item == nil
 
-		if js.EncryptFilenames {
-			//mhe = encrypt headers
-			common_arguments = append(common_arguments, "-mhe")
-		}
+	suffix := job.Settings.DiffSuffix
+	if is_full {
+		suffix = job.Settings.FullSuffix
 	}
 
-	//exclusions
-	for _, pattern := range js.Exclude {
-		common_arguments = append(common_arguments, "-xr!"+pattern)
-	}
+	//archive is written under temporary name and renamed when it is ready
+	final_filename := job.getArchiveName(suffix)
+	job_archive_filename := TempArchiveFilename(final_filename)
+	var err error
+	start_time := time.Now()
+	js := &job.Settings //convenience variable
 
-	//// RUN BASIC COMPRESSION
-	var basic_arguments = make([]string, len(common_arguments))
-	copy(basic_arguments, common_arguments)
+	is_empty := false
 
-	basic_arguments = append(basic_arguments,
-		"-mx"+strconv.Itoa(js.CompressionLevel), //compression level
-	)
+	// files deleted since full archive
+	var deleted []string
 
 	if is_full {
-		// exclude skip_compression patterns
-		for _, pattern := range js.SkipCompression {
-			basic_arguments = append(basic_arguments, "-xr!"+pattern)
-		}
+		err = job.Archiver.CreateFull(job_archive_filename)
+	} else {
+		is_empty, err = job.Archiver.CreateDiff(job_archive_filename, full_item.File.Path)
 	}
 
-	// final argument - whole folder to pack
-	basic_arguments = append(basic_arguments, filepath.Join(job.Path, "*"))
+	if err != nil && !IsWarning(err) {
+		job.removeFailedArchive(job_archive_filename)
 
-	// run command
-	main_output := job.runSevenZip(basic_arguments)
+		return "", &ArchiverError{Archive: filepath.Base(final_filename), Err: err}
+	}
 
-	//// ADD ITEMS WITHOUT COMPRESSION - works only for full archives now
-	if is_full && len(js.SkipCompression) > 0 {
-		var skip_compression_arguments = make([]string, len(common_arguments))
-		copy(skip_compression_arguments, common_arguments)
+	// archive is created anyway, warning is returned at the end
+	warning := err
 
-		skip_compression_arguments = append(skip_compression_arguments,
-			"-m0=copy", //do not compress at all
-		)
+	var base *JobArchiveFile
+	if !is_full {
+		base = full_item.File
 
-		// exclude skip_compression patterns
-		for _, pattern := range js.SkipCompression {
-			skip_compression_arguments = append(skip_compression_arguments, filepath.Join(job.Path, pattern))
+		if deleted, err = job.deletedSince(full_item.Chain(full_item.File)); err != nil {
+			job.Log("Error looking for deleted files: %s", err.Error())
+		} else if len(deleted) > 0 {
+			job.Log("Files deleted since full archive: %d", len(deleted))
+			is_empty = false
 		}
+	}
 
-		job.runSevenZip(skip_compression_arguments)
+	// directories are listed in manifest to remove deleted ones on restore
+	dirs, err := job.scanSourceDirs(base)
+	if err != nil {
+		job.Log("Error scanning directories: %s", err.Error())
+	} else if len(dirs.New) > 0 || len(dirs.Deleted) > 0 {
+		job.Log("Directories created since full archive: %d, deleted: %d", len(dirs.New), len(dirs.Deleted))
+		is_empty = false
 	}
 
 	var archType string
@@ -310,29 +532,17 @@ func (job *Job) createArchive(is_full bool, full_archive_path string) {
 		archType = "Diff"
 	}
 
-	job.Log("%s archive created: %s", archType, job_archive_filename)
-
-	// add packing duration to log
-	var duration_str string
-
-	duration := time.Since(start_time).Round(time.Second)
-	if duration < time.Second {
-		duration_str = "less than one second"
-	} else {
-		duration_str = duration.String()
-	}
-	job.Log("Packing took: %s", duration_str)
+	job.Log("%s archive created: %s", archType, final_filename)
+	job.logPackingDuration(start_time)
 
 	//check if empty diff was created
 	if !is_full {
-		is_empty := strings.Contains(main_output, "Add new data to archive: 0 files, 0 bytes")
-
 		if is_empty {
 			if !js.KeepEmptyDiff {
-				job.Log("Empty diff archive detected (%s). Removing it.", filepath.Base(job_archive_filename))
+				job.Log("Empty diff archive detected (%s). Removing it.", filepath.Base(final_filename))
 
-				if err = os.Remove(job_archive_filename); err != nil {
-					log.Fatalf("Error deleting file %s: %s", filepath.Base(job_archive_filename), err)
+				if err = job.discardArchive(job_archive_filename, final_filename, "empty diff"); err != nil {
+					return "", err
 				}
 			}
 		} else {
@@ -345,10 +555,10 @@ func (job *Job) createArchive(is_full bool, full_archive_path string) {
 
 						if err == nil {
 							if len(last_hash) > 0 && last_hash == prev_archive.Hash {
-								job.Log("Diff archive with same sha256 created (%s). Removing it.", filepath.Base(job_archive_filename))
+								job.Log("Diff archive with same sha256 created (%s). Removing it.", filepath.Base(final_filename))
 
-								if err = os.Remove(job_archive_filename); err != nil {
-									log.Fatalf("Error deleting file %s: %s", filepath.Base(job_archive_filename), err)
+								if err = job.discardArchive(job_archive_filename, final_filename, "same as previous diff"); err != nil {
+									return "", err
 								}
 							}
 						}
@@ -357,47 +567,348 @@ func (job *Job) createArchive(is_full bool, full_archive_path string) {
 			}
 		}
 	}
+
+	//archive was not removed, give it final name and describe it
+	if mttools.IsFileExists(job_archive_filename) {
+		if err = job.finishArchive(job_archive_filename, final_filename); err != nil {
+			return "", err
+		}
+
+		if err = job.writeManifest(job.Archiver, final_filename, base, deleted, dirs); err != nil {
+			warning = manifestWarning(warning, err)
+		}
+
+		return final_filename, warning
+	}
+
+	return "", warning
+}
+
+// archive without manifest is usable still (archiver lists it), so manifest error is reported as warning
+// added to archiver one
+func manifestWarning(warning error, err error) error {
+	err = fmt.Errorf("error writing manifest: %w", err)
+
+	var archiver_warning *Warning
+	if errors.As(warning, &archiver_warning) {
+		err = errors.Join(archiver_warning.Err, err)
+	}
+
+	return &Warning{Err: err}
+}
+
+// renames archive from temporary name to final one
+func (job *Job) finishArchive(temp_path string, archive_path string) error {
+	if err := os.Rename(temp_path, archive_path); err != nil {
+		job.removeFailedArchive(temp_path)
+
+		return &ArchiverError{Archive: filepath.Base(archive_path), Err: err}
+	}
+
+	return nil
+}
+
+// removes temporary archives left by interrupted or crashed runs
+func (job *Job) removeStaleTempFiles() {
+	if !mttools.IsDirExists(job.Settings.ArchivesPath) {
+		return
+	}
+
+	list, err := os.ReadDir(job.Settings.ArchivesPath)
+	if err != nil {
+		job.Log("Error reading archives directory: %s", err.Error())
+		return
+	}
+
+	re := job.archiveNameRegexp()
+
+	for _, entry := range list {
+		name := entry.Name()
+
+		if entry.IsDir() || !job.isTempArchiveName(re, name) {
+			continue
+		}
+
+		if job.Options.DryRun {
+			job.Log("Dry run: would remove unfinished archive left by previous run: %s", name)
+			continue
+		}
+
+		job.Log("Removing unfinished archive left by previous run: %s", name)
+
+		if err := os.Remove(filepath.Join(job.Settings.ArchivesPath, name)); err != nil {
+			job.Log("Error deleting file %s: %s", name, err.Error())
+		}
+	}
+}
+
+// removes archive left by failed archiver: it can not be trusted
+func (job *Job) removeFailedArchive(archive_path string) {
+	if !mttools.IsFileExists(archive_path) {
+		return
+	}
+
+	job.Log("Removing unfinished archive: %s", filepath.Base(archive_path))
+
+	if err := os.Remove(archive_path); err != nil {
+		job.Log("Error deleting file %s: %s", archive_path, err.Error())
+	}
+}
+
+// Creates archive with changes since latest archive of full item (full or diff or incremental one).
+// Returns created archive path or "" if archive was not created.
+func (job *Job) createIncrementalArchive(full_item *JobArchiveFullItem) (string, error) {
+	start_time := time.Now()
+
+	//state of directory at the moment latest archive was created
+	state, err := job.chainState(full_item.Chain(full_item.LastFile()))
+	if err != nil {
+		return "", fmt.Errorf("error reading previous archives: %w", err)
+	}
+
+	source, err := job.ScanSource()
+	if err != nil {
+		return "", fmt.Errorf("error scanning directory: %w", err)
+	}
+
+	dirs, err := job.scanSourceDirs(full_item.LastFile())
+	if err != nil {
+		return "", fmt.Errorf("error scanning directory: %w", err)
+	}
+
+	files := changedSourceFiles(source, state)
+	deleted := deletedSourceFiles(source, state)
+
+	//new empty directories are packed to be restored as well
+	files = append(files, leafDirs(dirs.New, files)...)
+
+	if len(files) == 0 && len(deleted) == 0 && len(dirs.Deleted) == 0 {
+		job.Log("No changes found since %s. Incremental archive is not created.", full_item.LastFile().Name)
+		return "", nil
+	}
+
+	//archive is written under temporary name and renamed when it is ready
+	final_filename := job.getArchiveName(job.Settings.IncSuffix)
+	job_archive_filename := TempArchiveFilename(final_filename)
+
+	// archive is created anyway if archiver reports warning only
+	warning := job.Archiver.CreateFromList(job_archive_filename, files)
+
+	if warning != nil && !IsWarning(warning) {
+		job.removeFailedArchive(job_archive_filename)
+
+		return "", &ArchiverError{Archive: filepath.Base(final_filename), Err: warning}
+	}
+
+	if err = job.finishArchive(job_archive_filename, final_filename); err != nil {
+		return "", err
+	}
+
+	job.Log("Incremental archive created: %s, files: %d, deleted: %d", final_filename, len(files), len(deleted))
+	job.logPackingDuration(start_time)
+
+	if err = job.writeManifest(job.Archiver, final_filename, full_item.LastFile(), deleted, dirs); err != nil {
+		warning = manifestWarning(warning, err)
+	}
+
+	return final_filename, warning
 }
 
-func (job *Job) runSevenZip(arguments []string) string {
-	job.Log("Command line: %s %s", Global.SevenZipCmd, strings.Join(arguments, " "))
+// Returns files state after unpacking all archives of chain one by one.
+func (job *Job) chainState(chain []*JobArchiveFile) (map[string]ArchiveEntry, error) {
+	state := make(map[string]ArchiveEntry)
+
+	for _, file := range chain {
+		//manifest is much faster than listing archive
+		if file.Manifest != nil {
+			for path, entry := range file.Manifest.State() {
+				state[path] = entry
+			}
+
+			for _, path := range file.Manifest.Deleted {
+				delete(state, path)
+			}
+
+			continue
+		}
+
+		archiver, err := job.archiverForFile(file.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err := archiver.List(file.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir {
+				continue
+			}
+
+			if entry.Deleted {
+				delete(state, entry.Path)
+			} else {
+				state[entry.Path] = entry
+			}
+		}
+	}
 
-	output, err := mttools.ExecCmdWaitAndPrint(Global.SevenZipCmd, arguments)
+	return state, nil
+}
 
+// Returns files deleted from job directory since last archive of chain was created.
+func (job *Job) deletedSince(chain []*JobArchiveFile) ([]string, error) {
+	state, err := job.chainState(chain)
 	if err != nil {
-		job.Log("Error running 7-zip: %s", err.Error())
+		return nil, err
+	}
+
+	source, err := job.ScanSource()
+	if err != nil {
+		return nil, err
+	}
+
+	return deletedSourceFiles(source, state), nil
+}
+
+// add packing duration to log
+func (job *Job) logPackingDuration(start_time time.Time) {
+	var duration_str string
+
+	duration := time.Since(start_time).Round(time.Second)
+	if duration < time.Second {
+		duration_str = "less than one second"
+	} else {
+		duration_str = duration.String()
 	}
 
-	if job.Settings.LogCommandOutput {
-		job.RawLog(output)
+	job.Log("Packing took: %s", duration_str)
+}
+
+// Cleanup deletes old archives according to retention settings. Nothing is deleted in dry run,
+// archives to be deleted are listed only.
+func (job *Job) Cleanup() (result JobCleanupResult, err error) {
+	unlock, err := job.lock("cleanup")
+	if err != nil {
+		return result, err
 	}
+	defer unlock()
 
-	return output
+	return job.cleanup(false)
 }
 
-func (job *Job) Cleanup() error {
+// new_full = new full archive is supposed to be in the list (dry run: it was not created really)
+func (job *Job) cleanup(new_full bool) (result JobCleanupResult, err error) {
 	job.Log("Cleaning up")
 
 	//always re-scan archives before cleaning up
-	job.ScanArchive(false)
+	if err := job.ScanArchive(false); err != nil {
+		return result, err
+	}
 
 	//delete FULL items
-	out_of_window_count := len(job.Archive.FullItemList) - job.Settings.MaxFullCount
+	full_items := cleanupItems(&job.Archive, &job.Settings, new_full)
 
-	for i := 0; i < out_of_window_count; i++ {
-		if job.Settings.KeepAtLeast > 0 {
-			if job.Archive.FullItemList[i].File.Age < job.Settings.KeepAtLeast {
-				continue
+	for _, full_item := range full_items {
+		if err := job.context().Err(); err != nil {
+			return result, err
+		}
+
+		if !job.Options.DryRun {
+			if err := job.removeFullItem(full_item, "cleanup"); err != nil {
+				return result, err
 			}
 		}
 
-		job.Archive.FullItemList[i].Unlink()
+		result.Deleted = append(result.Deleted, full_item.File.Name)
+		for _, diff_item := range full_item.DiffItemList {
+			result.Deleted = append(result.Deleted, diff_item.File.Name)
+		}
 	}
 
-	return nil
+	//thin out diffs of kept FULL items
+	thinned := thinDiffs(&job.Archive, &job.Settings, full_items, new_full)
+
+	for _, file := range thinned {
+		if err := job.context().Err(); err != nil {
+			return result, err
+		}
+
+		if !job.Options.DryRun {
+			if err := job.removeArchive(file, "diff thinning"); err != nil {
+				return result, err
+			}
+		}
+
+		result.Deleted = append(result.Deleted, file.Name)
+	}
+
+	//delete oldest FULL items until space limits are met
+	if job.Settings.HasSpaceLimits() {
+		free, err := job.freeSpace(0)
+		if err != nil {
+			return result, err
+		}
+
+		quota_items, reason := quotaCleanupItems(&job.Archive, &job.Settings, full_items, thinned, 0, free)
+
+		for _, full_item := range quota_items {
+			if err := job.context().Err(); err != nil {
+				return result, err
+			}
+
+			//deleted permanently: trash does not free space
+			if !job.Options.DryRun {
+				if err := full_item.UnlinkExcept(thinned); err != nil {
+					return result, err
+				}
+			}
+
+			result.Deleted = append(result.Deleted, full_item.File.Name)
+			for _, diff_item := range full_item.DiffItemList {
+				if !slices.Contains(thinned, diff_item.File) {
+					result.Deleted = append(result.Deleted, diff_item.File.Name)
+				}
+			}
+		}
+
+		if reason != "" {
+			job.Log("WARNING: space limits can not be met by deleting old archives: %s", reason)
+		}
+	}
+
+	if job.Options.DryRun {
+		for _, name := range result.Deleted {
+			job.Log("Dry run: would delete %s", name)
+		}
+
+		job.dryRunDelete(result.Deleted)
+	}
+
+	//purge archives kept in trash for too long
+	if job.Settings.TrashRetentionDays > 0 {
+		if result.Purged, err = job.TrashPurge(true); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
 }
 
-func (job *Job) prepareLogger() {
+// dry run: archives are not deleted really, but they are not listed by next scans as they would be
+func (job *Job) dryRunDelete(names []string) {
+	if job.dryRunDeleted == nil {
+		job.dryRunDeleted = make(map[string]bool)
+	}
+
+	for _, name := range names {
+		job.dryRunDeleted[name] = true
+	}
+}
+
+func (job *Job) prepareLogger() error {
 	var err error
 	logFilepath := filepath.Join(job.Settings.ArchivesPath, job.Settings.LogFilename)
 	logExists := mttools.IsFileExists(logFilepath)
@@ -405,7 +916,7 @@ func (job *Job) prepareLogger() {
 
 	if logExists {
 		if stat, err := os.Stat(logFilepath); err != nil {
-			log.Fatalf("Error checking log file size %s: %v", logFilepath, err)
+			return &LogError{Path: logFilepath, Err: err}
 		} else {
 			if stat.Size() > job.Settings.LogMaxSize { //need rotate
 				//logger is not ready yet, so screen only
@@ -415,12 +926,12 @@ func (job *Job) prepareLogger() {
 
 				if mttools.IsFileExists(prevLogFilepath) {
 					if err := os.Remove(prevLogFilepath); err != nil {
-						log.Fatalf("Error deleting file %s: %s", prevLogFilepath, err)
+						return &LogError{Path: logFilepath, Err: &DeleteError{Path: prevLogFilepath, Err: err}}
 					}
 				}
 
 				if err := os.Rename(logFilepath, prevLogFilepath); err != nil {
-					log.Fatalf("Error renaming file %s to %s: %s", logFilepath, prevLogFilepath, err)
+					return &LogError{Path: logFilepath, Err: err}
 				}
 
 				logExists = false //new one will be created
@@ -431,7 +942,7 @@ func (job *Job) prepareLogger() {
 
 	job.logfile, err = os.OpenFile(logFilepath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
-		log.Fatalf("Error opening log file %s: %v", logFilepath, err)
+		return &LogError{Path: logFilepath, Err: err}
 	}
 
 	if logExists {
@@ -446,7 +957,10 @@ func (job *Job) prepareLogger() {
 	} else if job.Settings.LogFormat == "json" {
 		logHandler = slog.NewJSONHandler(job.logfile, nil)
 	} else {
-		log.Panicf("Unknown log format %s", job.Settings.LogFormat)
+		job.logfile.Close()
+		job.logfile = nil
+
+		return &LogError{Path: logFilepath, Err: fmt.Errorf("unknown log format %s", job.Settings.LogFormat)}
 	}
 
 	job.logger = slog.New(logHandler)
@@ -454,6 +968,8 @@ func (job *Job) prepareLogger() {
 	if logRotated {
 		job.Log("Log file was rotated")
 	}
+
+	return nil
 }
 
 // Adds message to job's log
@@ -485,50 +1001,226 @@ func (job *Job) RawLog(content string) {
 	}
 }
 
-func (job *Job) Restore(to string, ja *JobArchiveFile) error {
+// Restore unpacks archive ja (with all archives it is based on) to directory. Directory should not
+// exist or should be empty. If paths are given only files matching them are restored (see MatchPathPatterns).
+// Archives should be scanned before (see ScanArchive).
+func (job *Job) Restore(to string, ja *JobArchiveFile, paths []string) (result JobRestoreResult, err error) {
+	job.Log("[%s v%s] Starting directory restore: %s", Global.AppName, Global.Version, job.Path)
+
+	//archives being restored should not be deleted by cleanup. Read-only archives directory can not be
+	//locked, but it can not be cleaned up either.
+	if isReadOnlyDir(job.Settings.ArchivesPath) {
+		job.Log("Archives directory is read-only, it is not locked")
+	} else {
+		unlock, err := job.lock("restore")
+		if err != nil {
+			return result, err
+		}
+		defer unlock()
+	}
+
+	if to, err = job.prepareRestoreDirectory(to); err != nil {
+		return result, err
+	}
+
+	job.Log("Archive to restore: %s", ja.Name)
 	job.Log("Destination directory: %s", to)
 
-	var full *JobArchiveFullItem
-	var diff *JobArchiveDiffItem
+	if len(paths) > 0 {
+		job.Log("Restoring paths: %s", strings.Join(paths, ", "))
+	}
+
+	full := job.Archive.FindItem(ja)
+	if full == nil {
+		return result, fmt.Errorf("Full archive not found")
+	}
+
+	for _, file := range full.Chain(ja) {
+		if err := job.context().Err(); err != nil {
+			return result, err
+		}
 
-	//Look for items for this file
-	for _, full_item := range job.Archive.FullItemList {
-		if full_item.File.Path == ja.Path {
-			full = &full_item
-			break
+		//skip archives having nothing to restore
+		if len(paths) > 0 && file.Manifest != nil && !file.Manifest.HasMatches(paths) {
+			job.Log("Skipping %s: no matching files", file.Name)
+			continue
 		}
 
-		for _, diff_item := range full_item.DiffItemList {
-			if diff_item.File.Path == ja.Path {
-				full = &full_item
-				diff = diff_item
-				break
-			}
+		//archives are unpacked by archiver they were created with
+		archiver, err := job.archiverForFile(file.Path)
+		if err != nil {
+			return result, err
+		}
+
+		if err := archiver.Check(); err != nil {
+			return result, err
+		}
+
+		if file.IsFull {
+			job.Log("Unpacking FULL archive %s", file.Path)
+		} else {
+			job.Log("Unpacking archive %s over previous ones", file.Path)
+		}
+
+		//first one is unpacked to empty directory, others overwrite files
+		if err := archiver.Extract(file.Path, to, len(result.Unpacked) > 0, paths); err != nil {
+			return result, err
+		}
+
+		result.Unpacked = append(result.Unpacked, file.Name)
+
+		removed, err := job.removeDeletedFiles(to, file, paths)
+		result.Removed = append(result.Removed, removed...)
+
+		if err != nil {
+			return result, err
 		}
 	}
 
-	if full == nil {
-		return fmt.Errorf("Full archive not found")
+	if len(result.Unpacked) == 0 {
+		job.Log("No files matching given paths found")
+		return result, nil
 	}
 
-	var common_arguments = []string{
-		"x",       // 7-zip command (eXtract), basic compression settings
-		"-o" + to, // Output directory
+	removed, err := job.removeDeletedDirs(to, full.Chain(ja), paths)
+	result.Removed = append(result.Removed, removed...)
+
+	return result, err
+}
+
+// checks restore destination directory: it should not exist (it is created then) or should be empty.
+// Returns absolute path to directory.
+func (job *Job) prepareRestoreDirectory(to string) (string, error) {
+	if to == "" {
+		return "", errors.New("destination directory is required")
 	}
 
-	if len(job.Settings.Password) > 0 {
-		common_arguments = append(common_arguments, "-p"+job.Settings.Password) // archive password
+	to, err := filepath.Abs(to)
+	if err != nil {
+		return "", err
 	}
 
-	job.Log("Unpacking FULL archive %s", full.File.Path)
-	job.runSevenZip(append(common_arguments, full.File.Path))
+	if mttools.IsDirExists(to) {
+		empty, err := mttools.IsDirEmpty(to)
+		if err != nil {
+			return "", err
+		}
 
-	if diff != nil {
-		common_arguments = append(common_arguments, "-aoa") //Overwrite all existing files without prompt
+		if !empty {
+			return "", fmt.Errorf("Directory %s is not empty", to)
+		}
+	} else {
+		if err := os.MkdirAll(to, 0777); err != nil {
+			return "", err
+		}
 
-		job.Log("Unpacking DIFF archive %s over FULL", diff.File.Path)
-		job.runSevenZip(append(common_arguments, diff.File.Path))
+		job.Log("Destination directory created: %s", to)
 	}
 
-	return nil
+	return to, nil
+}
+
+// removes files deleted in source directory since base archive was created (listed in manifest).
+// Returns removed files list.
+func (job *Job) removeDeletedFiles(to string, file *JobArchiveFile, paths []string) ([]string, error) {
+	var removed []string
+
+	if file.Manifest == nil {
+		if !file.IsFull {
+			job.Log("Archive %s has no manifest, deleted files (if any) can not be removed", file.Name)
+		}
+
+		return removed, nil
+	}
+
+	if len(file.Manifest.Deleted) == 0 {
+		return removed, nil
+	}
+
+	job.Log("Removing files deleted before %s was created: %d", file.Name, len(file.Manifest.Deleted))
+
+	for _, rel_path := range file.Manifest.Deleted {
+		if !MatchPathPatterns(paths, rel_path) {
+			continue
+		}
+
+		target, err := entryTargetPath(to, rel_path)
+		if err != nil {
+			return removed, err
+		}
+
+		if err := os.Remove(target); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return removed, err
+		}
+
+		removed = append(removed, rel_path)
+	}
+
+	return removed, nil
+}
+
+// removes directories deleted in source directory before archive was created: directories listed as deleted
+// in manifests of chain which were not created again by later archives. Deepest directories are removed first,
+// directories having files inside are kept. Returns removed directories list.
+func (job *Job) removeDeletedDirs(to string, chain []*JobArchiveFile, paths []string) ([]string, error) {
+	var removed []string
+
+	deleted := make(map[string]bool)
+
+	for _, file := range chain {
+		if file.Manifest == nil {
+			continue
+		}
+
+		for _, rel_path := range file.Manifest.DeletedDirs {
+			deleted[rel_path] = true
+		}
+
+		for _, rel_path := range file.Manifest.Dirs {
+			delete(deleted, rel_path)
+		}
+	}
+
+	//children go before their parents in reverse order
+	list := make([]string, 0, len(deleted))
+	for rel_path := range deleted {
+		if MatchPathPatterns(paths, rel_path) {
+			list = append(list, rel_path)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(list)))
+
+	for _, rel_path := range list {
+		target, err := entryTargetPath(to, rel_path)
+		if err != nil {
+			return removed, err
+		}
+
+		//path could be taken by file later
+		if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+			continue
+		}
+
+		if empty, err := mttools.IsDirEmpty(target); err != nil || !empty {
+			job.Log("Directory %s was deleted before archive was created but it is not empty, keeping it", rel_path)
+			continue
+		}
+
+		if err := os.Remove(target); err != nil {
+			return removed, err
+		}
+
+		removed = append(removed, rel_path)
+	}
+
+	if len(removed) > 0 {
+		job.Log("Directories deleted before archive was created removed: %d", len(removed))
+	}
+
+	return removed, nil
 }
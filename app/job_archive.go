@@ -2,27 +2,41 @@ package app
 
 import (
 	"fmt"
-	"log"
 	"math"
 	"os"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"sort"
-	"strings"
 	"time"
 
 	"github.com/mitoteam/mttools"
 )
 
+// Suffix added to archive filename while it is being created
+const TempArchiveSuffix = ".tmp"
+
+// TempArchiveFilename returns temporary filename archive is created with before it is ready.
+func TempArchiveFilename(archive_path string) string {
+	return archive_path + TempArchiveSuffix
+}
+
 type JobArchiveFile struct {
 	Name    string    //filename only
 	Path    string    //full path
 	IsFull  bool      //full or diff archive
+	IsInc   bool      //incremental archive (changes since previous archive)
 	Size    int64     //file size
 	ModTime time.Time //modification time
 	Time    time.Time //timestamp from archive name
 	Age     int       //age in days
 	Hash    string    //sha256 for archives
+	Host    string    //hostname from archive name ({host} placeholder, empty if not used)
+	Label   string    //label from archive name ({label} placeholder, empty if not used)
+	Seq     int       //sequence number from archive name (1 if there is no number in name)
+	Ext     string    //archive extension
+
+	Manifest *JobArchiveManifest //archive contents from sidecar file (nil if there is no manifest)
+	Pin      *JobArchivePin      //hold on archive from sidecar file (nil if archive is not pinned)
 }
 
 type JobArchiveFullItem struct {
@@ -41,10 +55,14 @@ type JobArchive struct {
 	FullItemList []JobArchiveFullItem // Full archives list with diffs listed in DiffItemList
 }
 
-func (job *Job) ScanArchive(addLog bool) {
+// ScanArchive reads archives directory and builds archives list and FULL -> DIFF[] tree.
+func (job *Job) ScanArchive(addLog bool) error {
 	files_list, err := os.ReadDir(job.Settings.ArchivesPath)
 	if err != nil {
-		log.Fatalln(err)
+		//dry run does not create archives directory
+		if !(job.Options.DryRun && os.IsNotExist(err)) {
+			return &ScanError{Path: job.Settings.ArchivesPath, Err: err}
+		}
 	}
 
 	job.Archive = JobArchive{
@@ -52,13 +70,8 @@ func (job *Job) ScanArchive(addLog bool) {
 		FullItemList: make([]JobArchiveFullItem, 0),
 	}
 
-	//prepare regexp and suffix
-	re := regexp.MustCompile(
-		"^" + regexp.QuoteMeta(job.Settings.ArchiveName) + "_(.*)_(" +
-			regexp.QuoteMeta(job.Settings.FullSuffix) + "|" + regexp.QuoteMeta(job.Settings.DiffSuffix) +
-			")\\.7z$",
-	)
-	full_suffix := "_" + job.Settings.FullSuffix + ".7z"
+	//archive filenames are parsed according to 'archive_name_template'
+	re := job.archiveNameRegexp()
 
 	//scan list
 	for _, value := range files_list {
@@ -68,7 +81,12 @@ func (job *Job) ScanArchive(addLog bool) {
 		}
 
 		//check if this is our file (by name)
-		if !re.MatchString(value.Name()) {
+		archive_file := JobArchiveFile{
+			Name: value.Name(),
+			Path: filepath.Join(job.Settings.ArchivesPath, value.Name()),
+		}
+
+		if !job.parseArchiveName(re, &archive_file) || !job.isOwnHostArchive(&archive_file) || job.dryRunDeleted[archive_file.Name] {
 			continue
 		}
 
@@ -77,50 +95,61 @@ func (job *Job) ScanArchive(addLog bool) {
 			continue
 		}
 
-		archive_file := JobArchiveFile{
-			Name:    value.Name(),
-			Path:    filepath.Join(job.Settings.ArchivesPath, value.Name()),
-			IsFull:  strings.HasSuffix(value.Name(), full_suffix),
-			Size:    info.Size(),
-			ModTime: info.ModTime(),
+		archive_file.Size = info.Size()
+		archive_file.ModTime = info.ModTime()
+
+		//timestamp can not be parsed from name
+		if archive_file.Time.IsZero() {
+			archive_file.Time = archive_file.ModTime
 		}
 
-		//try to parse timestamp
-		var filename_time time.Time
+		archive_file.Age = int(math.Ceil(time.Since(archive_file.Time).Hours() / 24))
 
-		matches := re.FindAllStringSubmatch(archive_file.Name, 1)
-		if len(matches) > 0 {
-			if len(matches[0]) > 1 {
-				filename_time, _ = time.Parse(job.Settings.DateFormat, matches[0][1])
+		//load manifest
+		if mttools.IsFileExists(ManifestFilename(archive_file.Path)) {
+			if archive_file.Manifest, err = LoadManifest(archive_file.Path); err != nil {
+				job.Log("Error reading manifest for %s: %s", archive_file.Name, err.Error())
+			} else if archive_file.Manifest.Encrypted != "" {
+				if err = job.decryptManifest(archive_file.Manifest); err != nil {
+					//archive is handled as one without manifest
+					job.Log("Error reading manifest for %s: %s", archive_file.Name, err.Error())
+					archive_file.Manifest = nil
+				}
 			}
 		}
 
-		if filename_time.IsZero() {
-			archive_file.Time = archive_file.ModTime
-		} else {
-			archive_file.Time = filename_time
-		}
-
-		archive_file.Age = int(math.Ceil(time.Since(archive_file.Time).Hours() / 24))
+		job.loadArchivePin(&archive_file)
 
-		//calculate hash for diffs
-		if !job.Settings.KeepSameDiff && !archive_file.IsFull {
+		//calculate hash for diffs (manifest has it already)
+		if archive_file.Manifest != nil && archive_file.Manifest.Size == archive_file.Size {
+			archive_file.Hash = archive_file.Manifest.Sha256
+		} else if !job.Settings.KeepSameDiff && !archive_file.IsFull && !archive_file.IsInc {
 			var hash string
 			hash, err = mttools.FileSha256(archive_file.Path)
 
 			if err == nil {
 				archive_file.Hash = hash
 			} else {
-				log.Fatal(err)
+				return &ScanError{Path: archive_file.Path, Err: err}
 			}
 		}
 
 		job.Archive.FilesList = append(job.Archive.FilesList, archive_file)
 	}
 
-	//sort by time
+	//sort by time (archives with same timestamp in name are sorted by creation order)
 	sort.Slice(job.Archive.FilesList, func(i, j int) bool {
-		return job.Archive.FilesList[i].Time.Before(job.Archive.FilesList[j].Time)
+		a, b := &job.Archive.FilesList[i], &job.Archive.FilesList[j]
+
+		if a.Time.Equal(b.Time) {
+			if a.ModTime.Equal(b.ModTime) {
+				return a.Seq < b.Seq
+			}
+
+			return a.ModTime.Before(b.ModTime)
+		}
+
+		return a.Time.Before(b.Time)
 	})
 
 	// build FULL -> DIFF[] tree
@@ -200,6 +229,8 @@ func (job *Job) ScanArchive(addLog bool) {
 			job.Log("%s", lastFullArchInfo)
 		}
 	}
+
+	return nil
 }
 
 func (ja *JobArchive) LastFile() *JobArchiveFile {
@@ -214,10 +245,37 @@ func (ja *JobArchive) LastFile() *JobArchiveFile {
 	return &ja.FilesList[len(ja.FilesList)-1]
 }
 
-func (ja *JobArchive) Dump(die bool) {
+func (ja *JobArchive) Dump() {
 	fmt.Println("------ PLAIN ARCHIVES LIST -------")
 	for _, raw_file := range ja.FilesList {
-		fmt.Println(raw_file.Name, mttools.FormatFileSize(raw_file.Size))
+		if raw_file.Manifest != nil {
+			fmt.Println(raw_file.Name, mttools.FormatFileSize(raw_file.Size), "files:", len(raw_file.Manifest.Files))
+		} else {
+			fmt.Println(raw_file.Name, mttools.FormatFileSize(raw_file.Size), "(no manifest)")
+		}
+	}
+
+	pinned := make([]*JobArchiveFile, 0)
+	for index := range ja.FilesList {
+		if ja.FilesList[index].Pin != nil {
+			pinned = append(pinned, &ja.FilesList[index])
+		}
+	}
+
+	if len(pinned) > 0 {
+		fmt.Println("\n------ PINNED ARCHIVES -------")
+		for _, file := range pinned {
+			reason := file.Pin.Reason
+			if reason == "" {
+				reason = "no reason given"
+			}
+
+			if file.Pin.Pinned.IsZero() {
+				fmt.Printf("%s: %s\n", file.Name, reason)
+			} else {
+				fmt.Printf("%s: %s (pinned %s)\n", file.Name, reason, file.Pin.Pinned.Format("2006-01-02 15:04:05"))
+			}
+		}
 	}
 
 	fmt.Println("\n------ DIFFs TREE -------")
@@ -230,31 +288,125 @@ func (ja *JobArchive) Dump(die bool) {
 			info_str += fmt.Sprintf(", diffs: %d (size %d%%)", len(full_item.DiffItemList), full_item.TotalDiffSizePercent)
 		}
 
+		if full_item.File.Pin != nil {
+			info_str += ", PINNED"
+		}
+
 		fmt.Printf("FULL: %s, %s\n", full_item.File.Name, info_str)
 
 		for _, diff_item := range full_item.DiffItemList {
-			fmt.Printf("    DIFF: %s, size %s = %d%%, age: %d\n", diff_item.File.Name, mttools.FormatFileSize(diff_item.File.Size), diff_item.DiffSizePercent, diff_item.File.Age)
+			kind := "DIFF"
+			if diff_item.File.IsInc {
+				kind = "INC"
+			}
+
+			pinned_str := ""
+			if diff_item.File.Pin != nil {
+				pinned_str = ", PINNED"
+			}
+
+			fmt.Printf("    %s: %s, size %s = %d%%, age: %d%s\n", kind, diff_item.File.Name, mttools.FormatFileSize(diff_item.File.Size), diff_item.DiffSizePercent, diff_item.File.Age, pinned_str)
 			if len(diff_item.File.Hash) > 0 {
 				fmt.Println("    " + diff_item.File.Hash)
 			}
 		}
 	}
+}
+
+// FindItem returns full item archive file belongs to (file is full archive itself or one of its diffs).
+func (ja *JobArchive) FindItem(file *JobArchiveFile) *JobArchiveFullItem {
+	for index := range ja.FullItemList {
+		full_item := &ja.FullItemList[index]
+
+		if full_item.File.Path == file.Path {
+			return full_item
+		}
+
+		for _, diff_item := range full_item.DiffItemList {
+			if diff_item.File.Path == file.Path {
+				return full_item
+			}
+		}
+	}
+
+	return nil
+}
+
+// Chain returns archives to unpack (in that order) to get directory state at the moment file
+// was created. Full archive goes first. Returns nil if file does not belong to this item.
+func (afi *JobArchiveFullItem) Chain(file *JobArchiveFile) []*JobArchiveFile {
+	if afi.File.Path == file.Path {
+		return []*JobArchiveFile{afi.File}
+	}
+
+	index := -1
+	for i, diff_item := range afi.DiffItemList {
+		if diff_item.File.Path == file.Path {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return nil
+	}
+
+	// go back through incremental archives until diff one (it has all changes since full) or full one
+	reversed := make([]*JobArchiveFile, 0)
+	for i := index; i >= 0; i-- {
+		reversed = append(reversed, afi.DiffItemList[i].File)
+
+		if !afi.DiffItemList[i].File.IsInc {
+			break
+		}
+	}
+
+	chain := []*JobArchiveFile{afi.File}
+	for i := len(reversed) - 1; i >= 0; i-- {
+		chain = append(chain, reversed[i])
+	}
+
+	return chain
+}
 
-	if die {
-		os.Exit(0)
+// LastFile returns latest archive of this item (full archive itself if there are no diffs).
+func (afi *JobArchiveFullItem) LastFile() *JobArchiveFile {
+	if len(afi.DiffItemList) == 0 {
+		return afi.File
 	}
+
+	return afi.DiffItemList[len(afi.DiffItemList)-1].File
+}
+
+// Unlink deletes full archive with all its diffs.
+func (afi *JobArchiveFullItem) Unlink() error {
+	return afi.UnlinkExcept(nil)
 }
 
-func (afi *JobArchiveFullItem) Unlink() {
+// UnlinkExcept deletes full archive with its diffs except given ones (already deleted by other rules).
+func (afi *JobArchiveFullItem) UnlinkExcept(skip []*JobArchiveFile) error {
 	//delete diffs
 	for _, diff_item := range afi.DiffItemList {
-		if err := os.Remove(diff_item.File.Path); err != nil {
-			log.Fatalf("Error deleting file %s: %s", diff_item.File.Path, err)
+		if slices.Contains(skip, diff_item.File) {
+			continue
+		}
+
+		if err := diff_item.File.Unlink(); err != nil {
+			return err
 		}
 	}
 
 	//delete itself
-	if err := os.Remove(afi.File.Path); err != nil {
-		log.Fatalf("Error deleting file %s: %s", afi.File.Path, err)
+	return afi.File.Unlink()
+}
+
+// Unlink deletes archive file with its manifest and pin. Files already gone are not an error.
+func (file *JobArchiveFile) Unlink() error {
+	for _, path := range []string{file.Path, ManifestFilename(file.Path), PinFilename(file.Path)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return &DeleteError{Path: path, Err: err}
+		}
 	}
+
+	return nil
 }
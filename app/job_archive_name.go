@@ -0,0 +1,257 @@
+package app
+
+import (
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitoteam/mttools"
+)
+
+// Default 'archive_name_template' value (same names as mtsaver always created)
+const DefaultArchiveNameTemplate = "{name}_{date}_{kind}"
+
+// Placeholders allowed in 'archive_name_template' setting
+var ArchiveNamePlaceholders = []string{"name", "host", "date", "seq", "label", "kind"}
+
+var archiveNamePlaceholderRe = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// characters not allowed in {host} and {label} values
+var archiveNameUnsafeRe = regexp.MustCompile(`[^0-9A-Za-z.-]+`)
+
+// checks 'archive_name_template' setting value
+func checkArchiveNameTemplate(template string) error {
+	if strings.ContainsAny(template, `/\`) {
+		return newSettingsError("archive_name_template should not contain path separators")
+	}
+
+	used := make(map[string]bool)
+
+	for _, match := range archiveNamePlaceholderRe.FindAllStringSubmatch(template, -1) {
+		if !slices.Contains(ArchiveNamePlaceholders, match[1]) {
+			return newSettingsError(
+				"unknown placeholder {%s} in archive_name_template. Valid placeholders: {%s}",
+				match[1], strings.Join(ArchiveNamePlaceholders, "}, {"),
+			)
+		}
+
+		if used[match[1]] {
+			return newSettingsError("placeholder {%s} is used more than once in archive_name_template", match[1])
+		}
+
+		used[match[1]] = true
+	}
+
+	//archive time and kind are required to build archives tree
+	for _, required := range []string{"date", "kind"} {
+		if !used[required] {
+			return newSettingsError("archive_name_template should contain {%s} placeholder", required)
+		}
+	}
+
+	return nil
+}
+
+// makes value safe to be used in filename
+func archiveNamePart(value string) string {
+	return strings.Trim(archiveNameUnsafeRe.ReplaceAllString(value, "-"), "-")
+}
+
+// hostname to be used in {host} placeholder
+func archiveNameHost() string {
+	host, err := os.Hostname()
+	if err != nil || archiveNamePart(host) == "" {
+		return "localhost"
+	}
+
+	return archiveNamePart(host)
+}
+
+// hostname of this job archives: archives of other hosts sharing archives directory are ignored if
+// 'archive_name_template' has {host} placeholder
+func (job *Job) archiveHost() string {
+	if host := archiveNamePart(job.Options.Host); host != "" {
+		return host
+	}
+
+	return archiveNameHost()
+}
+
+// checks if parsed archive belongs to this job host (always true if there is no {host} in template)
+func (job *Job) isOwnHostArchive(archive_file *JobArchiveFile) bool {
+	return !strings.Contains(job.Settings.ArchiveNameTemplate, "{host}") || archive_file.Host == job.archiveHost()
+}
+
+// values for archive name placeholders
+type archiveNameValues struct {
+	Kind  string    //full, diff or inc suffix
+	Time  time.Time //archive timestamp
+	Host  string
+	Label string
+	Seq   int
+	Ext   string //archiver extension
+}
+
+// builds archive filename (without directory) from template. If template has no {seq} placeholder
+// sequence number is added to the end of name only if it is greater than 1.
+func (job *Job) renderArchiveName(values archiveNameValues) string {
+	js := &job.Settings
+
+	name := archiveNamePlaceholderRe.ReplaceAllStringFunc(js.ArchiveNameTemplate, func(placeholder string) string {
+		switch placeholder {
+		case "{name}":
+			return js.ArchiveName
+		case "{host}":
+			return values.Host
+		case "{date}":
+			return values.Time.In(js.TimestampLocation()).Format(js.DateFormat)
+		case "{seq}":
+			return strconv.Itoa(values.Seq)
+		case "{label}":
+			return values.Label
+		case "{kind}":
+			return values.Kind
+		}
+
+		return placeholder
+	})
+
+	if !strings.Contains(js.ArchiveNameTemplate, "{seq}") && values.Seq > 1 {
+		name += "_" + strconv.Itoa(values.Seq)
+	}
+
+	return name + values.Ext
+}
+
+// getArchiveName returns full path for new archive. Name is guaranteed to be unique: sequence number
+// is increased until there is no archive (finished or being created) with same name.
+func (job *Job) getArchiveName(kind string) string {
+	values := archiveNameValues{
+		Kind:  kind,
+		Time:  time.Now(),
+		Host:  job.archiveHost(),
+		Label: archiveNamePart(job.Options.Label),
+		Ext:   job.Archiver.Extension(),
+	}
+
+	for values.Seq = 1; ; values.Seq++ {
+		archive_path := job.archivePath(job.renderArchiveName(values))
+
+		if !mttools.IsFileExists(archive_path) && !mttools.IsFileExists(TempArchiveFilename(archive_path)) {
+			return archive_path
+		}
+	}
+}
+
+// regexp for date formatted with layout: digits and letters groups are matched as is
+func dateLayoutRegexp(layout string) string {
+	var sb strings.Builder
+
+	for _, part := range regexp.MustCompile(`[0-9]+|[A-Za-z]+|.`).FindAllString(layout, -1) {
+		switch {
+		case part[0] >= '0' && part[0] <= '9':
+			sb.WriteString(`\d+`)
+		case (part[0] >= 'a' && part[0] <= 'z') || (part[0] >= 'A' && part[0] <= 'Z'):
+			sb.WriteString(`[A-Za-z]+`)
+		default:
+			sb.WriteString(regexp.QuoteMeta(part))
+		}
+	}
+
+	return sb.String()
+}
+
+// archiveNameRegexp builds regexp to parse archive filenames created with 'archive_name_template'.
+// Named groups: date, kind, host, label, seq and ext.
+func (job *Job) archiveNameRegexp() *regexp.Regexp {
+	js := &job.Settings
+
+	extensions := archiverExtensions(job)
+	for i := range extensions {
+		extensions[i] = regexp.QuoteMeta(extensions[i])
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+
+	position := 0
+	for _, match := range archiveNamePlaceholderRe.FindAllStringSubmatchIndex(js.ArchiveNameTemplate, -1) {
+		sb.WriteString(regexp.QuoteMeta(js.ArchiveNameTemplate[position:match[0]]))
+		position = match[1]
+
+		switch js.ArchiveNameTemplate[match[2]:match[3]] {
+		case "name":
+			sb.WriteString(regexp.QuoteMeta(js.ArchiveName))
+		case "host":
+			sb.WriteString(`(?P<host>[0-9A-Za-z.-]+)`)
+		case "date":
+			sb.WriteString(`(?P<date>` + dateLayoutRegexp(js.DateFormat) + `)`)
+		case "seq":
+			sb.WriteString(`(?P<seq>\d+)`)
+		case "label":
+			sb.WriteString(`(?P<label>[0-9A-Za-z.-]*)`)
+		case "kind":
+			sb.WriteString(`(?P<kind>` + regexp.QuoteMeta(js.FullSuffix) + "|" + regexp.QuoteMeta(js.DiffSuffix) + "|" +
+				regexp.QuoteMeta(js.IncSuffix) + `)`)
+		}
+	}
+
+	sb.WriteString(regexp.QuoteMeta(js.ArchiveNameTemplate[position:]))
+
+	//sequence number added to names made with template without {seq}
+	if !strings.Contains(js.ArchiveNameTemplate, "{seq}") {
+		sb.WriteString(`(?:_(?P<seq>\d+))?`)
+	}
+
+	sb.WriteString(`(?P<ext>` + strings.Join(extensions, "|") + `)$`)
+
+	return regexp.MustCompile(sb.String())
+}
+
+// parseArchiveName fills archive file fields from its name. Returns false if filename does not
+// match 'archive_name_template' (this is not our archive).
+func (job *Job) parseArchiveName(re *regexp.Regexp, archive_file *JobArchiveFile) bool {
+	matches := re.FindStringSubmatch(archive_file.Name)
+	if matches == nil {
+		return false
+	}
+
+	group := func(name string) string {
+		if index := re.SubexpIndex(name); index >= 0 {
+			return matches[index]
+		}
+
+		return ""
+	}
+
+	archive_file.IsFull = group("kind") == job.Settings.FullSuffix
+	archive_file.IsInc = group("kind") == job.Settings.IncSuffix
+	archive_file.Host = group("host")
+	archive_file.Label = group("label")
+	archive_file.Seq, _ = strconv.Atoi(group("seq"))
+
+	if archive_file.Seq == 0 {
+		archive_file.Seq = 1
+	}
+
+	archive_file.Ext = group("ext")
+
+	//timestamp (zero if it can not be parsed)
+	archive_file.Time, _ = time.ParseInLocation(job.Settings.DateFormat, group("date"), job.Settings.TimestampLocation())
+
+	return true
+}
+
+// checks if file is temporary archive of this job (archives being created by other hosts are not)
+func (job *Job) isTempArchiveName(re *regexp.Regexp, name string) bool {
+	if !strings.HasSuffix(name, TempArchiveSuffix) {
+		return false
+	}
+
+	archive_file := &JobArchiveFile{Name: strings.TrimSuffix(name, TempArchiveSuffix)}
+
+	return job.parseArchiveName(re, archive_file) && job.isOwnHostArchive(archive_file)
+}
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestArchiveNameRoundTrip(t *testing.T) {
+	archive_time := time.Date(2024, 3, 9, 22, 5, 7, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		setup  func(js *JobSettings)
+		values archiveNameValues
+		result string //expected filename
+	}{
+		{
+			name:   "default template",
+			setup:  func(js *JobSettings) { js.TimestampZone = "utc" },
+			values: archiveNameValues{Kind: "FULL", Seq: 1},
+			result: "test_2024-03-09_22-05-07_FULL.tar.zst",
+		},
+		{
+			name:   "sequence without {seq}",
+			setup:  func(js *JobSettings) { js.TimestampZone = "utc" },
+			values: archiveNameValues{Kind: "DIFF", Seq: 3},
+			result: "test_2024-03-09_22-05-07_DIFF_3.tar.zst",
+		},
+		{
+			name: "all placeholders",
+			setup: func(js *JobSettings) {
+				js.TimestampZone = "utc"
+				js.ArchiveNameTemplate = "{host}_{label}.{name}.{date}.{seq}.{kind}"
+			},
+			values: archiveNameValues{Kind: "INC", Host: "my-pc.lan", Label: "before-update", Seq: 2},
+			result: "my-pc.lan_before-update.test.2024-03-09_22-05-07.2.INC.tar.zst",
+		},
+		{
+			name: "empty label",
+			setup: func(js *JobSettings) {
+				js.TimestampZone = "utc"
+				js.ArchiveNameTemplate = "{kind}_{label}_{date}"
+			},
+			values: archiveNameValues{Kind: "FULL", Seq: 1},
+			result: "FULL__2024-03-09_22-05-07.tar.zst",
+		},
+		{
+			name: "date format with month name",
+			setup: func(js *JobSettings) {
+				js.TimestampZone = "utc"
+				js.DateFormat = "02Jan2006-1504.05"
+			},
+			values: archiveNameValues{Kind: "FULL", Seq: 1},
+			result: "test_09Mar2024-2205.07_FULL.tar.zst",
+		},
+		{
+			name: "custom suffixes",
+			setup: func(js *JobSettings) {
+				js.TimestampZone = "utc"
+				js.FullSuffix = "F"
+				js.DiffSuffix = "D"
+				js.IncSuffix = "I"
+			},
+			values: archiveNameValues{Kind: "D", Seq: 1},
+			result: "test_2024-03-09_22-05-07_D.tar.zst",
+		},
+		{
+			name: "local zone",
+			setup: func(js *JobSettings) {
+				js.TimestampZone = "local"
+			},
+			values: archiveNameValues{Kind: "FULL", Seq: 1},
+			result: "test_" + archive_time.Local().Format("2006-01-02_15-04-05") + "_FULL.tar.zst",
+		},
+	}
+
+	for _, test := range tests {
+		job := newTestJob(t, func(js *JobSettings) {
+			js.ArchiveName = "test"
+			test.setup(js)
+		})
+
+		values := test.values
+		values.Time = archive_time
+		values.Ext = job.Archiver.Extension()
+
+		name := job.renderArchiveName(values)
+		if name != test.result {
+			t.Errorf("%s: rendered %s, expected %s", test.name, name, test.result)
+			continue
+		}
+
+		file := &JobArchiveFile{Name: name}
+		if !job.parseArchiveName(job.archiveNameRegexp(), file) {
+			t.Errorf("%s: %s is not parsed", test.name, name)
+			continue
+		}
+
+		if !file.Time.Equal(archive_time) || file.Host != values.Host || file.Label != values.Label ||
+			file.Seq != values.Seq || file.Ext != values.Ext ||
+			file.IsFull != (values.Kind == job.Settings.FullSuffix) || file.IsInc != (values.Kind == job.Settings.IncSuffix) {
+			t.Errorf("%s: %s parsed as %+v", test.name, name, *file)
+		}
+	}
+}
+
+func TestArchiveNameForeignFiles(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) { js.ArchiveName = "test" })
+	re := job.archiveNameRegexp()
+
+	for _, name := range []string{
+		"other_2024-03-09_22-05-07_FULL.tar.zst",
+		"test_2024-03-09_22-05-07_FULL.zip.txt",
+		"test_2024-03-09_22-05-07_weekly.tar.zst",
+		"test_2024-03-09_22-05-07_FULL.tar.zst.mtsaver-manifest.json",
+		"test_2024-03-09_FULL.tar.zst",
+	} {
+		if job.parseArchiveName(re, &JobArchiveFile{Name: name}) {
+			t.Errorf("foreign file %s is parsed as archive", name)
+		}
+	}
+}
+
+func TestCheckArchiveNameTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		valid    bool
+	}{
+		{DefaultArchiveNameTemplate, true},
+		{"{date}{kind}", true},
+		{"{host}_{label}_{name}_{date}_{seq}_{kind}", true},
+		{"backups/{date}_{kind}", false},
+		{`{date}\{kind}`, false},
+		{"{name}_{date}_{kind}_{time}", false},
+		{"{name}_{date}_{kind}_{}", false},
+		{"{date}_{kind}_{date}", false},
+		{"{name}_{kind}", false},
+		{"{name}_{date}", false},
+	}
+
+	for _, test := range tests {
+		err := checkArchiveNameTemplate(test.template)
+
+		if (err == nil) != test.valid {
+			t.Errorf("checkArchiveNameTemplate(%q): %v", test.template, err)
+		}
+
+		if err != nil {
+			if _, ok := err.(*SettingsError); !ok {
+				t.Errorf("checkArchiveNameTemplate(%q): %T is not settings error", test.template, err)
+			}
+		}
+	}
+}
+
+func TestArchiveHostFilter(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) {
+		js.ArchiveName = "test"
+		js.ArchiveNameTemplate = "{host}_{name}_{date}_{kind}"
+	})
+
+	archive_time := time.Now().Add(-time.Hour)
+
+	add := func(host string, temp bool) string {
+		name := job.renderArchiveName(archiveNameValues{
+			Kind: job.Settings.FullSuffix, Time: archive_time, Host: host, Seq: 1, Ext: job.Archiver.Extension(),
+		})
+
+		path := job.archivePath(name)
+		if temp {
+			path = TempArchiveFilename(path)
+		}
+
+		if err := os.WriteFile(path, []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	own := add(job.archiveHost(), false)
+	other := add("other-pc", false)
+	own_temp := add(job.archiveHost(), true)
+	other_temp := add("other-pc", true)
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(job.Archive.FilesList) != 1 || job.Archive.FilesList[0].Path != own {
+		t.Errorf("archives of other hosts are not skipped: %+v", job.Archive.FilesList)
+	}
+
+	//archive being created by other host is not removed
+	job.removeStaleTempFiles()
+
+	if _, err := os.Stat(own_temp); !os.IsNotExist(err) {
+		t.Errorf("stale temporary archive is not removed: %v", err)
+	}
+
+	if _, err := os.Stat(other_temp); err != nil {
+		t.Errorf("temporary archive of other host is removed: %v", err)
+	}
+
+	//archives of other host are used when it is given explicitly
+	job.Options.Host = "other-pc"
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(job.Archive.FilesList) != 1 || job.Archive.FilesList[0].Path != other {
+		t.Errorf("archives of given host are not found: %+v", job.Archive.FilesList)
+	}
+}
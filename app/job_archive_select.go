@@ -0,0 +1,105 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// ArchiveSelector describes which archive to choose from archives list. Only one criteria should be set.
+type ArchiveSelector struct {
+	Latest bool      //newest archive
+	Name   string    //archive with exactly this filename
+	At     time.Time //newest archive created at or before this time
+	Before time.Time //newest archive created before this time
+	After  time.Time //oldest archive created after this time
+}
+
+// timestamp formats accepted in selectors
+var selectorTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// ParseSelectorTime parses timestamp given by user. It is always local time (whatever 'timestamp_zone'
+// setting is) and is compared to archive times as absolute moment.
+func (job *Job) ParseSelectorTime(value string) (time.Time, error) {
+	for _, layout := range selectorTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("can not parse time \"%s\", use \"YYYY-MM-DD hh:mm:ss\" format", value)
+}
+
+// IsEmpty checks if no criteria is set.
+func (s *ArchiveSelector) IsEmpty() bool {
+	return s.count() == 0
+}
+
+func (s *ArchiveSelector) count() int {
+	count := 0
+
+	for _, set := range []bool{s.Latest, s.Name != "", !s.At.IsZero(), !s.Before.IsZero(), !s.After.IsZero()} {
+		if set {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Select finds archive by selector criteria. FilesList should be sorted by time (ScanArchive does it).
+// Archives without full archive they are based on (diffs created before oldest full archive) can be
+// selected by name only: they can not be restored.
+func (ja *JobArchive) Select(s ArchiveSelector) (*JobArchiveFile, error) {
+	if s.count() != 1 {
+		return nil, errors.New("exactly one archive selection criteria should be given")
+	}
+
+	var found *JobArchiveFile
+
+	for index := range ja.FilesList {
+		file := &ja.FilesList[index]
+
+		if s.Name == "" && ja.FindItem(file) == nil {
+			continue
+		}
+
+		switch {
+		case s.Latest:
+			found = file
+
+		case s.Name != "":
+			if file.Name == filepath.Base(s.Name) {
+				return file, nil
+			}
+
+		case !s.At.IsZero():
+			if !file.Time.After(s.At) {
+				found = file
+			}
+
+		case !s.Before.IsZero():
+			if file.Time.Before(s.Before) {
+				found = file
+			}
+
+		case !s.After.IsZero():
+			if file.Time.After(s.After) {
+				return file, nil
+			}
+		}
+	}
+
+	if found == nil {
+		return nil, errors.New("no matching archive found")
+	}
+
+	return found, nil
+}
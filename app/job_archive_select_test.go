@@ -0,0 +1,100 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelect(t *testing.T) {
+	job := newTestJob(t, nil)
+	hour := time.Hour
+	now := time.Now().UTC().Truncate(time.Second)
+	full := job.Settings.FullSuffix
+	diff := job.Settings.DiffSuffix
+
+	//diff without full archive it is based on (full one was deleted manually for example)
+	orphan := addTestArchive(t, job, diff, now.Add(-10*hour), 10)
+	full1 := addTestArchive(t, job, full, now.Add(-8*hour), 100)
+	diff1 := addTestArchive(t, job, diff, now.Add(-6*hour), 10)
+	full2 := addTestArchive(t, job, full, now.Add(-4*hour), 100)
+	diff2 := addTestArchive(t, job, diff, now.Add(-2*hour), 10)
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		selector ArchiveSelector
+		expected string //"" = error expected
+	}{
+		{"latest", ArchiveSelector{Latest: true}, diff2},
+		{"name", ArchiveSelector{Name: diff1}, diff1},
+		{"name with path", ArchiveSelector{Name: "/some/dir/" + full2}, full2},
+		{"name of orphan", ArchiveSelector{Name: orphan}, orphan},
+		{"unknown name", ArchiveSelector{Name: "unknown.tar.zst"}, ""},
+		{"at", ArchiveSelector{At: now.Add(-5 * hour)}, diff1},
+		{"at exact time", ArchiveSelector{At: now.Add(-4 * hour)}, full2},
+		{"before exact time", ArchiveSelector{Before: now.Add(-4 * hour)}, diff1},
+		{"after", ArchiveSelector{After: now.Add(-7 * hour)}, diff1},
+		{"after exact time", ArchiveSelector{After: now.Add(-8 * hour)}, diff1},
+		{"after oldest", ArchiveSelector{After: now.Add(-9 * hour)}, full1},
+		{"after skips orphan", ArchiveSelector{After: now.Add(-11 * hour)}, full1},
+		{"at oldest time", ArchiveSelector{At: now.Add(-9 * hour)}, ""},
+		{"before oldest", ArchiveSelector{Before: now.Add(-8 * hour)}, ""},
+		{"after all", ArchiveSelector{After: now}, ""},
+		{"no criteria", ArchiveSelector{}, ""},
+		{"two criteria", ArchiveSelector{Latest: true, Name: diff1}, ""},
+	}
+
+	for _, test := range tests {
+		file, err := job.Archive.Select(test.selector)
+
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("%s: %s selected, error expected", test.name, file.Name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if file.Name != test.expected {
+			t.Errorf("%s: %s selected, expected %s", test.name, file.Name, test.expected)
+		}
+	}
+}
+
+func TestParseSelectorTime(t *testing.T) {
+	job := &Job{}
+	loc := time.Local //selector is local time whatever timestamp_zone is
+
+	tests := []struct {
+		value    string
+		expected time.Time //zero = error expected
+	}{
+		{"2024-03-09 22:05:07", time.Date(2024, 3, 9, 22, 5, 7, 0, loc)},
+		{"2024-03-09 22:05", time.Date(2024, 3, 9, 22, 5, 0, 0, loc)},
+		{"2024-03-09T22:05:07", time.Date(2024, 3, 9, 22, 5, 7, 0, loc)},
+		{"2024-03-09", time.Date(2024, 3, 9, 0, 0, 0, 0, loc)},
+		{"09.03.2024", time.Time{}},
+		{"yesterday", time.Time{}},
+	}
+
+	for _, test := range tests {
+		got, err := job.ParseSelectorTime(test.value)
+
+		if test.expected.IsZero() {
+			if err == nil {
+				t.Errorf("%s: no error", test.value)
+			}
+
+			continue
+		}
+
+		if err != nil || !got.Equal(test.expected) {
+			t.Errorf("%s: got %v, %v", test.value, got, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// builds full item from kinds list: "F" = full, "D" = diff, "I" = incremental archive
+func testFullItem(kinds string) *JobArchiveFullItem {
+	full_item := &JobArchiveFullItem{}
+
+	for index, kind := range strings.Split(kinds, ",") {
+		file := &JobArchiveFile{
+			Name:   strings.TrimSpace(kind) + string(rune('0'+index)),
+			IsFull: strings.TrimSpace(kind) == "F",
+			IsInc:  strings.TrimSpace(kind) == "I",
+		}
+		file.Path = "/archives/" + file.Name
+
+		if file.IsFull {
+			full_item.File = file
+		} else {
+			full_item.DiffItemList = append(full_item.DiffItemList, &JobArchiveDiffItem{File: file})
+		}
+	}
+
+	return full_item
+}
+
+func TestArchiveChain(t *testing.T) {
+	tests := []struct {
+		kinds    string
+		index    int //archive to get chain for: 0 = full one
+		expected []string
+	}{
+		{"F", 0, []string{"F0"}},
+		{"F, D, D", 0, []string{"F0"}},
+		{"F, D, D", 2, []string{"F0", "D2"}},
+		{"F, I, I, I", 3, []string{"F0", "I1", "I2", "I3"}},
+		{"F, I, I, I", 1, []string{"F0", "I1"}},
+		{"F, I, D, I, I", 4, []string{"F0", "D2", "I3", "I4"}},
+		{"F, I, D, I, I", 2, []string{"F0", "D2"}},
+		{"F, D, I", 1, []string{"F0", "D1"}},
+	}
+
+	for _, test := range tests {
+		full_item := testFullItem(test.kinds)
+
+		file := full_item.File
+		if test.index > 0 {
+			file = full_item.DiffItemList[test.index-1].File
+		}
+
+		chain := make([]string, 0)
+		for _, chain_file := range full_item.Chain(file) {
+			chain = append(chain, chain_file.Name)
+		}
+
+		if !slices.Equal(chain, test.expected) {
+			t.Errorf("%s: chain for %s is %v, expected %v", test.kinds, file.Name, chain, test.expected)
+		}
+	}
+
+	//other item file
+	if chain := testFullItem("F, D").Chain(&JobArchiveFile{Path: "/archives/other"}); chain != nil {
+		t.Errorf("chain for foreign file: %v", chain)
+	}
+}
+
+func TestScanArchiveError(t *testing.T) {
+	job := newTestJob(t, nil)
+
+	if err := os.Remove(job.Settings.ArchivesPath); err != nil {
+		t.Fatal(err)
+	}
+
+	err := job.ScanArchive(false)
+
+	if _, ok := err.(*ScanError); !ok {
+		t.Errorf("scan error expected, got %v", err)
+	}
+}
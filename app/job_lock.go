@@ -0,0 +1,232 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mitoteam/mttools"
+)
+
+// Lock file in archives directory: only one process can change archives at a time
+const LockFilename = "_mtsaver.lock"
+
+const (
+	lockRefreshInterval = time.Minute      //lock file modification time is updated this often while lock is held
+	lockStaleAge        = 10 * time.Minute //lock file not updated for this long is stale (holder crashed or its host is down)
+	lockPollInterval    = time.Second      //how often lock is checked while waiting for it
+)
+
+// JobLockInfo describes process holding archives directory lock. It is stored in lock file.
+type JobLockInfo struct {
+	Host    string    `json:"host"`    //computer name
+	PID     int       `json:"pid"`     //process id
+	Command string    `json:"command"` //what process does: run, cleanup, restore, migrate
+	Started time.Time `json:"started"` //when lock was taken
+
+	Updated time.Time `json:"-"` //lock file modification time (holder is alive)
+}
+
+// takes exclusive lock on archives directory for command. Returned function releases it. Lock held
+// by another process is waited for (Options.LockWait) or broken (Options.BreakLock), stale locks are
+// broken always. Nothing is locked in dry run or if archives directory does not exist.
+func (job *Job) lock(command string) (func(), error) {
+	unlock := func() {}
+
+	if job.Options.DryRun || !mttools.IsDirExists(job.Settings.ArchivesPath) {
+		return unlock, nil
+	}
+
+	path := filepath.Join(job.Settings.ArchivesPath, LockFilename)
+
+	host, _ := os.Hostname()
+	info := JobLockInfo{
+		Host:    host,
+		PID:     os.Getpid(),
+		Command: command,
+		Started: time.Now(),
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return unlock, err
+	}
+
+	var deadline time.Time
+	if job.Options.LockWait > 0 {
+		deadline = time.Now().Add(job.Options.LockWait)
+	}
+
+	break_lock := job.Options.BreakLock
+	waiting := false
+
+	for {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			_, err = file.Write(data)
+
+			if close_err := file.Close(); err == nil {
+				err = close_err
+			}
+
+			if err != nil {
+				os.Remove(path)
+				return unlock, fmt.Errorf("error writing lock file: %w", err)
+			}
+
+			break
+		}
+
+		if !os.IsExist(err) {
+			return unlock, fmt.Errorf("error creating lock file: %w", err)
+		}
+
+		holder, stale := readLock(path)
+
+		if stale || break_lock {
+			if stale {
+				job.Log("Breaking stale lock: %s", holder.String())
+			} else {
+				job.Log("Breaking lock (--break-lock): %s", holder.String())
+			}
+
+			broken, err := job.breakLock(path, holder)
+			if err != nil {
+				return unlock, err
+			}
+
+			//lock was changed meanwhile, it is checked again
+			if broken {
+				break_lock = false
+			}
+
+			continue
+		}
+
+		if job.Options.LockWait == 0 || (!deadline.IsZero() && time.Now().After(deadline)) {
+			return unlock, &LockError{Path: path, Holder: holder}
+		}
+
+		if !waiting {
+			job.Log("Waiting for lock: %s", holder.String())
+			waiting = true
+		}
+
+		select {
+		case <-job.context().Done():
+			return unlock, job.context().Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	//lock file is touched to show holder is alive
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(lockRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				os.Chtimes(path, now, now)
+			}
+		}
+	}()
+
+	unlock = func() {
+		close(done)
+
+		//lock could be broken and taken by another process
+		if holder, _ := readLock(path); !holder.sameHolder(info) {
+			job.Log("WARNING: lock was broken by another process: %s", holder.String())
+			return
+		}
+
+		if err := os.Remove(path); err != nil {
+			job.Log("Error removing lock file: %s", err.Error())
+		}
+	}
+
+	return unlock, nil
+}
+
+// removes lock file if it is still held by holder. Lock file is renamed to unique name first and checked
+// there: lock released and taken by another process after holder was read is put back then. Returns false
+// if lock was not removed because it was changed.
+func (job *Job) breakLock(path string, holder JobLockInfo) (bool, error) {
+	broken_path := fmt.Sprintf("%s.broken.%d.%d", path, os.Getpid(), time.Now().UnixNano())
+
+	if err := os.Rename(path, broken_path); err != nil {
+		if os.IsNotExist(err) {
+			//released or broken by another process
+			return false, nil
+		}
+
+		return false, &DeleteError{Path: path, Err: err}
+	}
+
+	if current, _ := readLock(broken_path); current.sameHolder(holder) && current.Updated.Equal(holder.Updated) {
+		if err := os.Remove(broken_path); err != nil {
+			return false, &DeleteError{Path: broken_path, Err: err}
+		}
+
+		return true, nil
+	}
+
+	//hard link does not replace lock taken by another process meanwhile
+	if err := os.Link(broken_path, path); err == nil || os.IsExist(err) {
+		os.Remove(broken_path)
+	} else if err := os.Rename(broken_path, path); err != nil {
+		//file system without hard links
+		return false, err
+	}
+
+	return false, nil
+}
+
+// reads lock file. Lock is stale if its holder is not running on this computer or lock file was not
+// updated for too long (holder on another computer is not alive).
+func readLock(path string) (info JobLockInfo, stale bool) {
+	file_info, err := os.Stat(path)
+	if err != nil {
+		//lock was released
+		return info, true
+	}
+
+	info.Updated = file_info.ModTime()
+	stale = time.Since(info.Updated) > lockStaleAge
+
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &info) != nil {
+		//lock file is being written right now or it is broken
+		return info, stale
+	}
+
+	if host, _ := os.Hostname(); info.Host == host && info.PID != os.Getpid() && !processExists(info.PID) {
+		stale = true
+	}
+
+	return info, stale
+}
+
+// checks if lock is held by same process
+func (info JobLockInfo) sameHolder(other JobLockInfo) bool {
+	return info.PID == other.PID && info.Host == other.Host && info.Started.Equal(other.Started)
+}
+
+// String describes lock holder.
+func (info JobLockInfo) String() string {
+	if info.PID == 0 {
+		return "lock file can not be read"
+	}
+
+	return fmt.Sprintf(
+		"'%s' command started %s on %s (PID %d)", info.Command, info.Started.Format("2006-01-02 15:04:05"), info.Host, info.PID,
+	)
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writes lock file held by holder
+func writeTestLock(t *testing.T, path string, holder JobLockInfo) {
+	t.Helper()
+
+	data, err := json.Marshal(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	job := newTestJob(t, nil)
+	path := filepath.Join(job.Settings.ArchivesPath, LockFilename)
+	host, _ := os.Hostname()
+
+	//held by running process (this one)
+	writeTestLock(t, path, JobLockInfo{Host: host, PID: os.Getpid(), Command: "run", Started: time.Now()})
+
+	var lock_error *LockError
+	if _, err := job.lock("cleanup"); !errors.As(err, &lock_error) {
+		t.Errorf("held lock is taken: %v", err)
+	}
+
+	//stale: holder process does not exist anymore
+	writeTestLock(t, path, JobLockInfo{Host: host, PID: 1 << 30, Command: "run", Started: time.Now()})
+
+	unlock, err := job.lock("cleanup")
+	if err != nil {
+		t.Fatalf("stale lock is not broken: %s", err)
+	}
+
+	if holder, _ := readLock(path); holder.PID != os.Getpid() || holder.Command != "cleanup" {
+		t.Errorf("lock is held by %s", holder.String())
+	}
+
+	unlock()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("lock file is not removed")
+	}
+}
+
+func TestLockWaitAndBreak(t *testing.T) {
+	job := newTestJob(t, nil)
+	path := filepath.Join(job.Settings.ArchivesPath, LockFilename)
+	host, _ := os.Hostname()
+
+	writeTestLock(t, path, JobLockInfo{Host: host, PID: os.Getpid(), Command: "run", Started: time.Now()})
+
+	//waiting is limited
+	job.Options.LockWait = 10 * time.Millisecond
+
+	var lock_error *LockError
+	if _, err := job.lock("cleanup"); !errors.As(err, &lock_error) {
+		t.Errorf("held lock is taken after waiting: %v", err)
+	}
+
+	//waiting forever is interrupted by canceled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	job.SetContext(ctx)
+	job.Options.LockWait = -1
+
+	if _, err := job.lock("cleanup"); !errors.Is(err, context.Canceled) {
+		t.Errorf("waiting is not interrupted: %v", err)
+	}
+
+	job.SetContext(context.Background())
+	job.Options.LockWait = 0
+	job.Options.BreakLock = true
+
+	unlock, err := job.lock("cleanup")
+	if err != nil {
+		t.Fatalf("lock is not broken: %s", err)
+	}
+
+	unlock()
+}
+
+func TestBreakLockChanged(t *testing.T) {
+	job := newTestJob(t, nil)
+	path := filepath.Join(job.Settings.ArchivesPath, LockFilename)
+
+	writeTestLock(t, path, JobLockInfo{Host: "other", PID: 1, Command: "run", Started: time.Now().Add(-time.Hour)})
+	stale, _ := readLock(path)
+
+	//lock is released and taken by another process after stale one was read
+	taken := JobLockInfo{Host: "other", PID: 2, Command: "cleanup", Started: time.Now()}
+	writeTestLock(t, path, taken)
+
+	broken, err := job.breakLock(path, stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if broken {
+		t.Errorf("lock taken by another process is broken")
+	}
+
+	if holder, _ := readLock(path); !holder.sameHolder(taken) {
+		t.Errorf("lock is held by %s", holder.String())
+	}
+
+	list, _ := filepath.Glob(path + ".broken.*")
+	if len(list) > 0 {
+		t.Errorf("temporary files left: %v", list)
+	}
+
+	//not changed lock is broken
+	current, _ := readLock(path)
+
+	if broken, err := job.breakLock(path, current); err != nil || !broken {
+		t.Errorf("lock is not broken: %v", err)
+	}
+}
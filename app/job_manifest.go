@@ -0,0 +1,387 @@
+package app
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mitoteam/mttools"
+)
+
+// Suffix added to archive filename for its manifest sidecar file
+const ManifestSuffix = ".manifest.json"
+
+// JobArchiveManifest describes archive contents. It is stored next to archive in sidecar file.
+// If archive filenames are encrypted contents (files and directories lists) are stored encrypted
+// with archive password in Encrypted field only.
+type JobArchiveManifest struct {
+	Archive string                   `json:"archive"`           //archive filename
+	Sha256  string                   `json:"sha256"`            //archive file hash
+	Size    int64                    `json:"size"`              //archive file size
+	Created time.Time                `json:"created"`           //archive creation time
+	Base    string                   `json:"base,omitempty"`    //filename of archive this one is based on (for diffs and incrementals)
+	Files   []JobArchiveManifestFile `json:"files"`             //packed files
+	Deleted []string                 `json:"deleted,omitempty"` //files deleted since base archive (for diffs and incrementals)
+
+	Dirs        []string `json:"dirs"`                   //all job directory subdirectories when archive was created (nil in older manifests)
+	DeletedDirs []string `json:"deleted_dirs,omitempty"` //directories deleted since base archive (for diffs and incrementals)
+
+	Encrypted string `json:"encrypted,omitempty"` //encrypted contents (see ScanArchive)
+}
+
+// JobArchiveManifestFile is single file packed to archive. Mode and Sha256 describe file as it was packed:
+// archivers not reporting them take them from source file if it was not changed since it was packed
+// (they are empty otherwise).
+type JobArchiveManifestFile struct {
+	Path    string      `json:"path"` //slash separated, relative to job directory
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	Mode    fs.FileMode `json:"mode"`
+	Sha256  string      `json:"sha256,omitempty"` //empty for symlinks
+}
+
+// manifest contents stored in Encrypted field
+type jobArchiveManifestContents struct {
+	Files       []JobArchiveManifestFile `json:"files"`
+	Deleted     []string                 `json:"deleted,omitempty"`
+	Dirs        []string                 `json:"dirs"`
+	DeletedDirs []string                 `json:"deleted_dirs,omitempty"`
+}
+
+// ManifestFilename returns manifest sidecar filename for archive.
+func ManifestFilename(archive_path string) string {
+	return archive_path + ManifestSuffix
+}
+
+// LoadManifest reads archive manifest sidecar file.
+func LoadManifest(archive_path string) (*JobArchiveManifest, error) {
+	data, err := os.ReadFile(ManifestFilename(archive_path))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &JobArchiveManifest{}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// Save writes manifest to sidecar file for archive. Encrypted manifest contents are not written in plain text.
+func (m *JobArchiveManifest) Save(archive_path string) error {
+	var value any = m
+
+	if m.Encrypted != "" {
+		//empty fields of outer struct hide same fields of manifest
+		value = struct {
+			*JobArchiveManifest
+			Files       any `json:"files,omitempty"`
+			Deleted     any `json:"deleted,omitempty"`
+			Dirs        any `json:"dirs,omitempty"`
+			DeletedDirs any `json:"deleted_dirs,omitempty"`
+		}{JobArchiveManifest: m}
+	}
+
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(ManifestFilename(archive_path), data, 0666)
+}
+
+// State returns manifest files mapped by path (deleted files are not included).
+func (m *JobArchiveManifest) State() map[string]ArchiveEntry {
+	state := make(map[string]ArchiveEntry, len(m.Files))
+
+	for _, file := range m.Files {
+		state[file.Path] = ArchiveEntry{
+			Path:    file.Path,
+			Size:    file.Size,
+			ModTime: file.ModTime,
+		}
+	}
+
+	return state
+}
+
+// HasMatches checks if there are files (packed or deleted) matching any of patterns (see MatchPathPatterns).
+func (m *JobArchiveManifest) HasMatches(patterns []string) bool {
+	for _, file := range m.Files {
+		if MatchPathPatterns(patterns, file.Path) {
+			return true
+		}
+	}
+
+	for _, rel_path := range m.Deleted {
+		if MatchPathPatterns(patterns, rel_path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// builds and saves manifest for just created archive: archive contents are listed, files modes and
+// hashes are taken from archiver (or source files). base = archive this one is based on, deleted = files
+// deleted since base archive, dirs = job directory subdirectories. Contents are encrypted if archive
+// filenames are.
+func (job *Job) writeManifest(
+	archiver Archiver, archive_path string, base *JobArchiveFile, deleted []string, dirs sourceDirs,
+) error {
+	info, err := os.Stat(archive_path)
+	if err != nil {
+		return err
+	}
+
+	manifest := &JobArchiveManifest{
+		Archive: filepath.Base(archive_path),
+		Size:    info.Size(),
+		Created: time.Now(),
+		Files:   make([]JobArchiveManifestFile, 0),
+		Deleted: deleted,
+
+		Dirs:        dirs.All,
+		DeletedDirs: dirs.Deleted,
+	}
+
+	if base != nil {
+		manifest.Base = base.Name
+	}
+
+	if manifest.Sha256, err = mttools.FileSha256(archive_path); err != nil {
+		return err
+	}
+
+	entries, err := archiver.List(archive_path)
+	if err != nil {
+		return err
+	}
+
+	//files as they were packed
+	var packed map[string]PackedFile
+	var known map[string]JobArchiveManifestFile
+	if packed_files, ok := archiver.(ArchiverPackedFiles); ok {
+		packed = packed_files.PackedFiles()
+	} else {
+		known = job.knownFileHashes()
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir || entry.Deleted {
+			continue
+		}
+
+		file := JobArchiveManifestFile{
+			Path:    entry.Path,
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+		}
+
+		if packed != nil {
+			packed_file, ok := packed[entry.Path]
+			if !ok {
+				//entry was not packed completely (file truncated while being packed)
+				continue
+			}
+
+			file.Mode = packed_file.Mode
+			file.Sha256 = packed_file.Sha256
+		} else {
+			//source file describes packed one only if it was not changed since
+			source_path := filepath.Join(job.Path, filepath.FromSlash(entry.Path))
+
+			if source_info, err := os.Lstat(source_path); err == nil && sameModTime(source_info.ModTime(), entry.ModTime) {
+				file.Mode = source_info.Mode()
+
+				if source_info.Mode().IsRegular() && source_info.Size() == entry.Size {
+					//file is hashed only if it was changed since it was listed in manifest of existing archive
+					if known_file, ok := known[entry.Path]; ok && known_file.Size == entry.Size && sameModTime(known_file.ModTime, entry.ModTime) {
+						file.Sha256 = known_file.Sha256
+					} else {
+						file.Sha256, _ = mttools.FileSha256(source_path)
+					}
+				}
+			}
+		}
+
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+
+	if job.Settings.Password != "" && job.Settings.EncryptFilenames {
+		if err := job.encryptManifest(manifest); err != nil {
+			return err
+		}
+	}
+
+	if err := manifest.Save(archive_path); err != nil {
+		return err
+	}
+
+	job.Log(
+		"Manifest written: %s (files: %d, deleted: %d, deleted directories: %d)",
+		filepath.Base(ManifestFilename(archive_path)), len(manifest.Files), len(manifest.Deleted), len(manifest.DeletedDirs),
+	)
+
+	return nil
+}
+
+// hashed files from manifests of existing archives (loaded by ScanArchive), newer archives take precedence
+func (job *Job) knownFileHashes() map[string]JobArchiveManifestFile {
+	known := make(map[string]JobArchiveManifestFile)
+
+	for _, archive_file := range job.Archive.FilesList {
+		if archive_file.Manifest == nil {
+			continue
+		}
+
+		for _, file := range archive_file.Manifest.Files {
+			if file.Sha256 != "" {
+				known[file.Path] = file
+			}
+		}
+	}
+
+	return known
+}
+
+// manifests contents encryption key is derived from password
+const (
+	manifestKeyIterations = 100000
+	manifestSaltSize      = 16
+)
+
+// key manifests contents are encrypted with
+type manifestKey struct {
+	salt []byte
+	key  []byte
+}
+
+// returns key for salt. Key is derived from password once per job: same salt is used for all manifests
+// written by job. salt = nil returns key for new manifest.
+func (job *Job) getManifestKey(salt []byte) (*manifestKey, error) {
+	if job.manifestKey != nil && (salt == nil || bytes.Equal(salt, job.manifestKey.salt)) {
+		return job.manifestKey, nil
+	}
+
+	if salt == nil {
+		salt = make([]byte, manifestSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := pbkdf2.Key(sha256.New, job.Settings.Password, salt, manifestKeyIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	job.manifestKey = &manifestKey{salt: salt, key: key}
+
+	return job.manifestKey, nil
+}
+
+// encrypts manifest contents with archives password (AES-GCM). Encrypted = salt + nonce + sealed contents, base64 encoded.
+func (job *Job) encryptManifest(m *JobArchiveManifest) error {
+	key, err := job.getManifestKey(nil)
+	if err != nil {
+		return err
+	}
+
+	plain, err := json.Marshal(jobArchiveManifestContents{
+		Files:       m.Files,
+		Deleted:     m.Deleted,
+		Dirs:        m.Dirs,
+		DeletedDirs: m.DeletedDirs,
+	})
+	if err != nil {
+		return err
+	}
+
+	gcm, err := manifestCipher(key.key)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, manifestSaltSize+gcm.NonceSize())
+	copy(data, key.salt)
+
+	nonce := data[manifestSaltSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	m.Encrypted = base64.StdEncoding.EncodeToString(gcm.Seal(data, nonce, plain, nil))
+
+	return nil
+}
+
+// decrypts manifest contents with archives password
+func (job *Job) decryptManifest(m *JobArchiveManifest) error {
+	if job.Settings.Password == "" {
+		return errors.New("manifest is encrypted, password is required to read it")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(m.Encrypted)
+	if err != nil || len(data) < manifestSaltSize {
+		return errors.New("encrypted manifest is damaged")
+	}
+
+	key, err := job.getManifestKey(data[:manifestSaltSize])
+	if err != nil {
+		return err
+	}
+
+	gcm, err := manifestCipher(key.key)
+	if err != nil {
+		return err
+	}
+
+	data = data[manifestSaltSize:]
+	if len(data) < gcm.NonceSize() {
+		return errors.New("encrypted manifest is damaged")
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return errors.New("manifest can not be decrypted: wrong password or manifest is damaged")
+	}
+
+	contents := jobArchiveManifestContents{}
+	if err := json.Unmarshal(plain, &contents); err != nil {
+		return err
+	}
+
+	m.Files = contents.Files
+	m.Deleted = contents.Deleted
+	m.Dirs = contents.Dirs
+	m.DeletedDirs = contents.DeletedDirs
+
+	return nil
+}
+
+func manifestCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,207 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManifestWritten(t *testing.T) {
+	job := newTestJob(t, testDateFormat)
+
+	writeTestFiles(t, job.Path, map[string]string{
+		"a.txt":     "first file",
+		"dir/b.txt": "second file",
+	})
+
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := job.Archive.LastFile()
+	if archive == nil {
+		t.Fatal("archive is not created")
+	}
+
+	manifest, err := LoadManifest(archive.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if manifest.Archive != archive.Name || manifest.Size != archive.Size {
+		t.Errorf("wrong archive in manifest: %s (%d bytes)", manifest.Archive, manifest.Size)
+	}
+
+	if len(manifest.Files) != 2 || manifest.Files[0].Path != "a.txt" || manifest.Files[1].Path != "dir/b.txt" {
+		t.Fatalf("wrong files in manifest: %+v", manifest.Files)
+	}
+
+	hash := sha256.Sum256([]byte("first file"))
+
+	if file := manifest.Files[0]; file.Size != 10 || file.Sha256 != hex.EncodeToString(hash[:]) || !file.Mode.IsRegular() {
+		t.Errorf("wrong file in manifest: %+v", file)
+	}
+}
+
+func TestManifestEncryption(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) {
+		js.Password = "secret"
+	})
+
+	archive_path := filepath.Join(job.Settings.ArchivesPath, "test.7z")
+
+	manifest := &JobArchiveManifest{
+		Archive:     "test.7z",
+		Files:       []JobArchiveManifestFile{{Path: "private/report.txt", Size: 10}},
+		Deleted:     []string{"private/old.txt"},
+		Dirs:        []string{"private"},
+		DeletedDirs: []string{"private/gone"},
+	}
+
+	if err := job.encryptManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manifest.Save(archive_path); err != nil {
+		t.Fatal(err)
+	}
+
+	//no paths in plain text
+	data, err := os.ReadFile(ManifestFilename(archive_path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "private") {
+		t.Errorf("encrypted manifest contains paths: %s", data)
+	}
+
+	loaded, err := LoadManifest(archive_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//new job derives key again
+	reader := newTestJob(t, func(js *JobSettings) {
+		js.Password = "secret"
+	})
+
+	if err := reader.decryptManifest(loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.Files) != 1 || loaded.Files[0].Path != "private/report.txt" || loaded.Deleted[0] != "private/old.txt" ||
+		loaded.Dirs[0] != "private" || loaded.DeletedDirs[0] != "private/gone" {
+		t.Errorf("decrypted manifest differs: %+v", loaded)
+	}
+
+	for _, password := range []string{"", "wrong"} {
+		loaded, _ := LoadManifest(archive_path)
+
+		wrong := newTestJob(t, func(js *JobSettings) {
+			js.Password = password
+		})
+
+		if err := wrong.decryptManifest(loaded); err == nil {
+			t.Errorf("manifest decrypted with password '%s'", password)
+		}
+	}
+}
+
+func TestManifestPackedFiles(t *testing.T) {
+	job := newTestJob(t, nil)
+
+	file_path := filepath.Join(job.Path, "file.txt")
+	if err := os.WriteFile(file_path, []byte("packed"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	archive_path := filepath.Join(job.Settings.ArchivesPath, "test.tar.zst")
+	if err := job.Archiver.CreateFull(archive_path); err != nil {
+		t.Fatal(err)
+	}
+
+	//file is changed after packing, manifest describes packed one
+	if err := os.WriteFile(file_path, []byte("changed"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.writeManifest(job.Archiver, archive_path, nil, nil, sourceDirs{}); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := LoadManifest(archive_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256([]byte("packed"))
+
+	if len(manifest.Files) != 1 || manifest.Files[0].Sha256 != hex.EncodeToString(hash[:]) || !manifest.Files[0].Mode.IsRegular() {
+		t.Errorf("manifest does not describe packed file: %+v", manifest.Files)
+	}
+}
+
+// archiver that does not report packed files (like 7z one)
+type listOnlyArchiver struct {
+	Archiver
+}
+
+func TestManifestKnownHashes(t *testing.T) {
+	job := newTestJob(t, nil)
+
+	for _, name := range []string{"known.txt", "changed.txt", "new.txt"} {
+		if err := os.WriteFile(filepath.Join(job.Path, name), []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive_path := filepath.Join(job.Settings.ArchivesPath, "test.tar.zst")
+	if err := job.Archiver.CreateFull(archive_path); err != nil {
+		t.Fatal(err)
+	}
+
+	known_info, err := os.Stat(filepath.Join(job.Path, "known.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//hashes from manifest of existing archive are reused only for files not changed since
+	job.Archive.FilesList = []JobArchiveFile{{Manifest: &JobArchiveManifest{Files: []JobArchiveManifestFile{
+		{Path: "known.txt", Size: known_info.Size(), ModTime: known_info.ModTime(), Sha256: "known"},
+		{Path: "changed.txt", Size: int64(len("changed.txt")), ModTime: known_info.ModTime().Add(-time.Hour), Sha256: "changed"},
+	}}}}
+
+	if err := job.writeManifest(listOnlyArchiver{job.Archiver}, archive_path, nil, nil, sourceDirs{}); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := LoadManifest(archive_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"known.txt": "known"}
+	for _, name := range []string{"changed.txt", "new.txt"} {
+		hash := sha256.Sum256([]byte(name))
+		expected[name] = hex.EncodeToString(hash[:])
+	}
+
+	if len(manifest.Files) != len(expected) {
+		t.Fatalf("unexpected manifest files: %+v", manifest.Files)
+	}
+
+	for _, file := range manifest.Files {
+		if file.Sha256 != expected[file.Path] {
+			t.Errorf("%s: hash %s, expected %s", file.Path, file.Sha256, expected[file.Path])
+		}
+	}
+}
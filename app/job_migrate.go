@@ -0,0 +1,320 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mitoteam/mttools"
+)
+
+// Suffix for archives being renamed by Migrate (when new name is taken by other archive being renamed)
+const migrateSuffix = ".migrating"
+
+// JobMigrateItem is single archive rename done (or planned) by Migrate.
+type JobMigrateItem struct {
+	From string // previous archive filename
+	To   string // new archive filename
+}
+
+// Migrate renames archives created with previous naming settings (from) so they match current job
+// settings. Manifests are renamed together with archives and references to renamed archives in them
+// are updated. Nothing is changed if dry_run is set, planned renames are returned only.
+func (job *Job) Migrate(from JobSettings, dry_run bool) ([]JobMigrateItem, error) {
+	if !dry_run {
+		unlock, err := job.lock("migrate")
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	//scan archives as they were named with previous settings
+	old_job := &Job{
+		Path:     job.Path,
+		Settings: from,
+		Archiver: job.Archiver,
+		Options:  job.Options,
+		ctx:      job.ctx,
+	}
+
+	if err := old_job.ScanArchive(false); err != nil {
+		return nil, err
+	}
+
+	//archives renamed before should not be shifted again
+	if from.TimestampZone != job.Settings.TimestampZone {
+		if err := job.detectTimestampZones(old_job.Archive.FilesList); err != nil {
+			return nil, err
+		}
+	}
+
+	items := job.planMigration(old_job.Archive.FilesList)
+	if dry_run {
+		return items, nil
+	}
+
+	if len(items) == 0 {
+		job.Log("Nothing to rename, archives match current settings")
+		return items, nil
+	}
+
+	//renaming is not interrupted: it is fast and archives should not be left half-renamed
+	if err := job.context().Err(); err != nil {
+		return nil, err
+	}
+
+	job.Log("Renaming %d archives to match current settings", len(items))
+
+	//first pass: archives with free new names are renamed right away, others are moved aside
+	deferred := make([]JobMigrateItem, 0)
+
+	for _, item := range items {
+		if mttools.IsFileExists(job.archivePath(item.To)) {
+			if err := job.renameArchive(item.From, item.From+migrateSuffix); err != nil {
+				return nil, err
+			}
+
+			deferred = append(deferred, item)
+			continue
+		}
+
+		if err := job.renameArchive(item.From, item.To); err != nil {
+			return nil, err
+		}
+
+		job.Log("Renamed %s -> %s", item.From, item.To)
+	}
+
+	//second pass: all previous names are free now
+	for _, item := range deferred {
+		if err := job.renameArchive(item.From+migrateSuffix, item.To); err != nil {
+			return nil, err
+		}
+
+		job.Log("Renamed %s -> %s", item.From, item.To)
+	}
+
+	//update references in manifests
+	renamed := make(map[string]string, len(items))
+	for _, item := range items {
+		renamed[item.From] = item.To
+	}
+
+	for _, file := range old_job.Archive.FilesList {
+		name := file.Name
+		if new_name, ok := renamed[name]; ok {
+			name = new_name
+		}
+
+		if err := job.updateManifestNames(job.archivePath(name), renamed); err != nil {
+			return nil, err
+		}
+	}
+
+	//settings copy should describe archives as they are named now
+	if err := job.replaceSettingsCopy(from); err != nil {
+		return items, err
+	}
+
+	return items, nil
+}
+
+// LoadPreviousSettings loads settings file archives were created with (to be used with Migrate).
+// Missing values get same defaults as usual. Archives directory is always taken from current settings:
+// archives are renamed in place.
+func (job *Job) LoadPreviousSettings(filename string) (JobSettings, error) {
+	settings := NewJobSettings()
+
+	if err := settings.LoadFromFile(filename); err != nil {
+		return settings, err
+	}
+
+	settings.ArchivesPath = job.Settings.ArchivesPath
+
+	if err := settings.ApplyDefaultsAndCheck(job.Path, job.Options); err != nil {
+		if settings_error, ok := err.(*SettingsError); ok {
+			settings_error.Filename = filename
+		}
+
+		return settings, err
+	}
+
+	return settings, nil
+}
+
+// saves current settings copy and removes one saved with previous settings (if its name differs)
+func (job *Job) replaceSettingsCopy(from JobSettings) error {
+	if err := job.Settings.SaveCopy(job.Path); err != nil {
+		return err
+	}
+
+	if from.SettingsCopyFilename() != job.Settings.SettingsCopyFilename() && mttools.IsFileExists(from.SettingsCopyFilename()) {
+		job.Log("Removing previous settings copy: %s", filepath.Base(from.SettingsCopyFilename()))
+
+		if err := os.Remove(from.SettingsCopyFilename()); err != nil {
+			return &DeleteError{Path: from.SettingsCopyFilename(), Err: err}
+		}
+	}
+
+	return nil
+}
+
+// Archives timestamps are parsed in previous time zone. Archives named in current zone already (migrated
+// before or created after 'timestamp_zone' was changed) get timestamps parsed in current zone. Zone is detected
+// by manifest creation time (or archive file modification time if there is no manifest): archive timestamp is
+// taken right before it is packed, so it is the one closest to creation time but not later. Error is returned
+// if zone can not be detected.
+func (job *Job) detectTimestampZones(files []JobArchiveFile) error {
+	for index := range files {
+		file := &files[index]
+
+		t := file.Time
+		current := time.Date(
+			t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), job.Settings.TimestampLocation(),
+		)
+
+		//zones do not differ at this time
+		if current.Equal(t) {
+			continue
+		}
+
+		created := file.ModTime
+		created_source := "archive modification time"
+
+		if mttools.IsFileExists(ManifestFilename(file.Path)) {
+			manifest, err := LoadManifest(file.Path)
+			if err != nil {
+				return fmt.Errorf("can not detect time zone of archive %s timestamp: %w", file.Name, err)
+			}
+
+			created = manifest.Created
+			created_source = "manifest creation time"
+		}
+
+		//archive timestamp has seconds precision at most
+		latest := created.Add(time.Minute)
+
+		previous_ok := !t.After(latest)
+		current_ok := !current.After(latest)
+
+		if current_ok && (!previous_ok || current.After(t)) {
+			file.Time = current
+		} else if !previous_ok {
+			return fmt.Errorf(
+				"can not detect time zone of archive %s timestamp: it is later than %s %s",
+				file.Name, created_source, created.Format(time.RFC3339),
+			)
+		}
+	}
+
+	return nil
+}
+
+// builds new names for archives. Archives already matching current settings are skipped.
+func (job *Job) planMigration(files []JobArchiveFile) []JobMigrateItem {
+	items := make([]JobMigrateItem, 0)
+
+	values_list := make([]archiveNameValues, len(files))
+	sources := make(map[string]bool, len(files))
+	taken := make(map[string]bool, len(files)) //new names (and names of archives not being renamed)
+
+	for index, file := range files {
+		values := archiveNameValues{
+			Kind:  job.Settings.DiffSuffix,
+			Time:  file.Time,
+			Host:  file.Host,
+			Label: file.Label,
+			Seq:   file.Seq,
+			Ext:   file.Ext,
+		}
+
+		if file.IsFull {
+			values.Kind = job.Settings.FullSuffix
+		} else if file.IsInc {
+			values.Kind = job.Settings.IncSuffix
+		}
+
+		if values.Host == "" {
+			values.Host = job.archiveHost()
+		}
+
+		values_list[index] = values
+		sources[file.Name] = true
+
+		if job.renderArchiveName(values) == file.Name {
+			taken[file.Name] = true
+		}
+	}
+
+	for index, file := range files {
+		values := values_list[index]
+
+		if job.renderArchiveName(values) == file.Name {
+			continue
+		}
+
+		//increase sequence number until name is unique
+		for {
+			name := job.renderArchiveName(values)
+
+			if !taken[name] && (sources[name] || !mttools.IsFileExists(job.archivePath(name))) {
+				taken[name] = true
+				items = append(items, JobMigrateItem{From: file.Name, To: name})
+				break
+			}
+
+			values.Seq++
+		}
+	}
+
+	return items
+}
+
+// renames archive with its manifest and pin
+func (job *Job) renameArchive(from string, to string) error {
+	if err := os.Rename(job.archivePath(from), job.archivePath(to)); err != nil {
+		return err
+	}
+
+	for _, sidecar := range []func(string) string{ManifestFilename, PinFilename} {
+		if mttools.IsFileExists(sidecar(job.archivePath(from))) {
+			if err := os.Rename(sidecar(job.archivePath(from)), sidecar(job.archivePath(to))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// updates archive and base archive filenames in manifest of renamed archive
+func (job *Job) updateManifestNames(archive_path string, renamed map[string]string) error {
+	if !mttools.IsFileExists(ManifestFilename(archive_path)) {
+		return nil
+	}
+
+	manifest, err := LoadManifest(archive_path)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+
+	if new_name, ok := renamed[manifest.Archive]; ok {
+		manifest.Archive = new_name
+		changed = true
+	}
+
+	if new_name, ok := renamed[manifest.Base]; ok {
+		manifest.Base = new_name
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return manifest.Save(archive_path)
+}
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateTemplate(t *testing.T) {
+	job := newTestJob(t, testDateFormat)
+
+	writeTestFiles(t, job.Path, map[string]string{"a.txt": "first file"})
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFiles(t, job.Path, map[string]string{"b.txt": "second file"})
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	from := job.Settings
+	job.Settings.ArchiveNameTemplate = "{date}.{kind}.{name}"
+
+	items, err := job.Migrate(from, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 {
+		t.Fatalf("two renames expected: %+v", items)
+	}
+
+	for _, item := range items {
+		if _, err := os.Stat(job.archivePath(item.From)); !os.IsNotExist(err) {
+			t.Errorf("%s is not renamed", item.From)
+		}
+
+		if _, err := os.Stat(ManifestFilename(job.archivePath(item.To))); err != nil {
+			t.Errorf("%s manifest is not renamed: %v", item.To, err)
+		}
+	}
+
+	//second run changes nothing
+	if items, err := job.Migrate(from, true); err != nil || len(items) != 0 {
+		t.Errorf("archives are renamed again: %+v, %v", items, err)
+	}
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(job.Archive.FilesList) != 2 {
+		t.Fatalf("renamed archives are not found: %+v", job.Archive.FilesList)
+	}
+
+	diff := job.Archive.FilesList[1]
+	full := job.Archive.FilesList[0]
+
+	if diff.Manifest == nil || diff.Manifest.Archive != diff.Name || diff.Manifest.Base != full.Name {
+		t.Errorf("manifest references are not updated: %+v", diff.Manifest)
+	}
+
+	//renamed chain is restorable
+	to := t.TempDir()
+	if _, err := job.Restore(to, &diff, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readTestTree(t, to); got["a.txt"] != "first file" || got["b.txt"] != "second file" {
+		t.Errorf("restored from renamed archives: %v", got)
+	}
+}
+
+func TestMigrateFromSettings(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) { js.ArchiveName = "old" })
+
+	writeTestFiles(t, job.Path, map[string]string{"a.txt": "first file"})
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	//settings copy saved by run describes existing archives
+	old_copy := job.Settings.SettingsCopyFilename()
+
+	job.Settings.ArchiveName = "new"
+
+	from, err := job.LoadPreviousSettings(old_copy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if from.ArchiveName != "old" || from.ArchivesPath != job.Settings.ArchivesPath {
+		t.Fatalf("previous settings are not loaded: %+v", from)
+	}
+
+	items, err := job.Migrate(from, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 1 || !strings.HasPrefix(items[0].To, "new_") {
+		t.Errorf("unexpected renames: %+v", items)
+	}
+
+	if _, err := os.Stat(old_copy); !os.IsNotExist(err) {
+		t.Errorf("previous settings copy is not removed: %v", err)
+	}
+
+	if _, err := os.Stat(job.Settings.SettingsCopyFilename()); err != nil {
+		t.Errorf("current settings copy is not saved: %v", err)
+	}
+
+	if _, err := job.LoadPreviousSettings(filepath.Join(t.TempDir(), "missing.yml")); err == nil {
+		t.Errorf("no error for missing settings file")
+	}
+}
+
+func TestMigrateTimestampZone(t *testing.T) {
+	//local zone should differ from UTC
+	local := time.Local
+	time.Local = time.FixedZone("TEST", 3*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	//zone is detected by manifest creation time or by archive modification time without manifests
+	for _, with_manifest := range []bool{true, false} {
+		job := newTestJob(t, func(js *JobSettings) {
+			js.TimestampZone = "utc"
+		})
+
+		from := job.Settings
+		from.TimestampZone = "local"
+		from_job := &Job{Settings: from}
+
+		//archive created (and its manifest written) right after its timestamp
+		add := func(namer *Job, archive_time time.Time, kind string) string {
+			name := namer.renderArchiveName(archiveNameValues{Kind: kind, Time: archive_time, Seq: 1, Ext: job.Archiver.Extension()})
+			path := job.archivePath(name)
+			created := archive_time.Add(10 * time.Second)
+
+			if err := os.WriteFile(path, []byte(name), 0666); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.Chtimes(path, created, created); err != nil {
+				t.Fatal(err)
+			}
+
+			if with_manifest {
+				manifest := &JobArchiveManifest{Archive: name, Created: created}
+				if err := manifest.Save(path); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			return name
+		}
+
+		now := time.Now().Truncate(time.Second)
+
+		//named in previous zone
+		old_full := add(from_job, now.Add(-48*time.Hour), job.Settings.FullSuffix)
+		//named in current zone already
+		add(job, now.Add(-24*time.Hour), job.Settings.DiffSuffix)
+
+		items, err := job.Migrate(from, true)
+		if err != nil {
+			t.Fatalf("with manifests %v: %s", with_manifest, err)
+		}
+
+		expected := job.renderArchiveName(archiveNameValues{
+			Kind: job.Settings.FullSuffix, Time: now.Add(-48 * time.Hour), Seq: 1, Ext: job.Archiver.Extension(),
+		})
+
+		if len(items) != 1 || items[0].From != old_full || items[0].To != expected {
+			t.Fatalf("with manifests %v: planned renames: %+v, expected %s -> %s", with_manifest, items, old_full, expected)
+		}
+
+		if _, err := job.Migrate(from, false); err != nil {
+			t.Fatalf("with manifests %v: %s", with_manifest, err)
+		}
+
+		//second run changes nothing
+		if items, err := job.Migrate(from, true); err != nil || len(items) != 0 {
+			t.Errorf("with manifests %v: archives are renamed again: %+v, %v", with_manifest, items, err)
+		}
+	}
+}
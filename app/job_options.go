@@ -1,25 +1,44 @@
 package app
 
-// Runtime options for job
-var JobRuntimeOptions struct {
-	SettingsFilename string
-	NoConsole        bool // global: --no-console
+import "time"
 
-	ForceFull        bool   // run --force-full
-	ForceDiff        bool   // run --force-diff
-	Solid            bool   // run --solid
-	Password         string // run/restore --password <string>
-	EncryptFilenames bool   // run --encrypt-filenames
-	NoLog            bool   // run --no-log
+// JobOptions are runtime options for job: command line arguments or options given by program embedding
+// mtsaver. They override values from settings file.
+type JobOptions struct {
+	SettingsFilename string // settings filename or path (DefaultSettingsFilename if empty)
+	SevenZipCmd      string // 7-Zip executable to run, empty = 7-Zip is not available (see DetectSevenZip)
 
-	DefaultsFrom string // init --defaults-from <string>
-	Print        bool   // init --print
+	ForceFull        bool   // create full archive
+	ForceDiff        bool   // create diff (or incremental) archive
+	Solid            bool   // create solid archives
+	Password         string // archives password (overrides 'password' setting)
+	EncryptFilenames bool   // encrypt filenames in archives
+	NoLog            bool   // do not write log file
+	Label            string // value for {label} placeholder in 'archive_name_template'
+	Host             string // value for {host} placeholder in 'archive_name_template' (computer name if empty)
+	SkipRunBefore    bool   // do not run 'run_before' commands
+	DryRun           bool   // show what would be done without changing anything on disk (no log file as well)
+	Pin              bool   // pin created archive (Label is used as pin reason)
 
-	RestoreTo     string // restore --to
-	RestoreLatest bool   // restore --latest
+	LockWait  time.Duration // how long to wait for archives directory lock held by another process: 0 = do not wait, negative = wait until released
+	BreakLock bool          // remove archives directory lock held by another process
 }
 
-func init() {
-	//default values
-	JobRuntimeOptions.SettingsFilename = DefaultSettingsFilename
+// JobRunResult describes what was done by Run.
+type JobRunResult struct {
+	Created string   // created archive filename (empty if archive was not created: no changes found for example)
+	Planned string   // dry run: archive filename that would be created
+	Deleted []string // archives deleted by cleanup (dry run: archives that would be deleted)
+}
+
+// JobCleanupResult describes what was done by Cleanup.
+type JobCleanupResult struct {
+	Deleted []string // deleted archives filenames (dry run: archives that would be deleted)
+	Purged  []string // expired archives purged from trash (dry run: archives that would be purged)
+}
+
+// JobRestoreResult describes what was done by Restore.
+type JobRestoreResult struct {
+	Unpacked []string // unpacked archives filenames
+	Removed  []string // files removed because they were deleted before restored archive was created
 }
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mitoteam/mttools"
+)
+
+// Suffix added to archive filename for its pin sidecar file
+const PinSuffix = ".pin.json"
+
+// JobArchivePin is hold on archive: pinned archives (with all archives needed to restore them)
+// are never deleted by cleanup. It is stored next to archive in sidecar file.
+type JobArchivePin struct {
+	Reason string    `json:"reason,omitempty"` //why archive is pinned
+	Pinned time.Time `json:"pinned"`           //when archive was pinned
+}
+
+// PinFilename returns pin sidecar filename for archive.
+func PinFilename(archive_path string) string {
+	return archive_path + PinSuffix
+}
+
+// LoadPin reads archive pin sidecar file.
+func LoadPin(archive_path string) (*JobArchivePin, error) {
+	data, err := os.ReadFile(PinFilename(archive_path))
+	if err != nil {
+		return nil, err
+	}
+
+	pin := &JobArchivePin{}
+
+	if err := json.Unmarshal(data, pin); err != nil {
+		return nil, err
+	}
+
+	return pin, nil
+}
+
+// Save writes pin to sidecar file for archive.
+func (p *JobArchivePin) Save(archive_path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(PinFilename(archive_path), data, 0666)
+}
+
+// IsPinned checks if full archive itself or any of its diffs is pinned.
+func (afi *JobArchiveFullItem) IsPinned() bool {
+	if afi.File.Pin != nil {
+		return true
+	}
+
+	for _, diff_item := range afi.DiffItemList {
+		if diff_item.File.Pin != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Pin puts hold on archive with given filename: it is kept by cleanup with all archives needed
+// to restore it. Pinning already pinned archive updates reason.
+func (job *Job) Pin(name string, reason string) (*JobArchiveFile, error) {
+	if err := job.ScanArchive(false); err != nil {
+		return nil, err
+	}
+
+	file, err := job.Archive.Select(ArchiveSelector{Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("archive %s not found", filepath.Base(name))
+	}
+
+	if err := job.pinArchive(file.Path, reason); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Unpin removes hold from archive with given filename. Archive is deleted by next cleanup if
+// retention settings do not keep it.
+func (job *Job) Unpin(name string) (*JobArchiveFile, error) {
+	if err := job.ScanArchive(false); err != nil {
+		return nil, err
+	}
+
+	file, err := job.Archive.Select(ArchiveSelector{Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("archive %s not found", filepath.Base(name))
+	}
+
+	if file.Pin == nil {
+		return nil, errors.New("archive " + file.Name + " is not pinned")
+	}
+
+	if err := os.Remove(PinFilename(file.Path)); err != nil {
+		return nil, &DeleteError{Path: PinFilename(file.Path), Err: err}
+	}
+
+	job.Log("Archive unpinned: %s", file.Name)
+
+	return file, nil
+}
+
+// writes pin sidecar for archive
+func (job *Job) pinArchive(archive_path string, reason string) error {
+	pin := &JobArchivePin{
+		Reason: reason,
+		Pinned: time.Now(),
+	}
+
+	if err := pin.Save(archive_path); err != nil {
+		return err
+	}
+
+	if reason == "" {
+		job.Log("Archive pinned: %s", filepath.Base(archive_path))
+	} else {
+		job.Log("Archive pinned: %s (%s)", filepath.Base(archive_path), reason)
+	}
+
+	return nil
+}
+
+// loads pin sidecar for scanned archive if there is one
+func (job *Job) loadArchivePin(archive_file *JobArchiveFile) {
+	if !mttools.IsFileExists(PinFilename(archive_file.Path)) {
+		return
+	}
+
+	var err error
+
+	if archive_file.Pin, err = LoadPin(archive_file.Path); err != nil {
+		//broken pin file still holds archive
+		job.Log("Error reading pin for %s: %s", archive_file.Name, err.Error())
+		archive_file.Pin = &JobArchivePin{Reason: "pin file can not be read"}
+	}
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPinUnpin(t *testing.T) {
+	job := newTestJob(t, nil)
+	names := addTestArchives(t, job, []testArchiveSpec{{kind: "F", days: 2, size: 100}, {kind: "D", days: 1, size: 10}})
+
+	if _, err := job.Pin(names[1], "before upgrade"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	full_item := &job.Archive.FullItemList[0]
+	if pin := full_item.DiffItemList[0].File.Pin; pin == nil || pin.Reason != "before upgrade" {
+		t.Errorf("diff archive is not pinned: %+v", pin)
+	}
+
+	//pinned diff holds its full archive
+	if full_item.File.Pin != nil || !full_item.IsPinned() {
+		t.Errorf("full archive pinned: %v, held: %v", full_item.File.Pin != nil, full_item.IsPinned())
+	}
+
+	//broken pin file still holds archive
+	if err := os.WriteFile(PinFilename(job.archivePath(names[0])), []byte("{broken"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Archive.FullItemList[0].File.Pin == nil {
+		t.Errorf("archive with broken pin file is not pinned")
+	}
+
+	for _, name := range names {
+		if _, err := job.Unpin(name); err != nil {
+			t.Errorf("unpin %s: %s", name, err)
+		}
+
+		if _, err := os.Stat(PinFilename(job.archivePath(name))); !os.IsNotExist(err) {
+			t.Errorf("pin file of %s is not removed: %v", name, err)
+		}
+	}
+
+	if _, err := job.Unpin(names[0]); err == nil {
+		t.Errorf("no error unpinning archive which is not pinned")
+	}
+
+	if _, err := job.Pin("missing.tar.zst", ""); err == nil {
+		t.Errorf("no error pinning missing archive")
+	}
+}
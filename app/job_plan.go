@@ -0,0 +1,348 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mitoteam/mttools"
+)
+
+// JobPlanRule is single rule checked to choose between full and diff archive.
+type JobPlanRule struct {
+	Setting   string // setting rule is based on
+	Value     string // current value
+	Threshold string // setting value rule compares with ("not set" if rule is disabled)
+	Triggered bool   // rule requires full archive
+	Message   string // explanation for triggered rule
+}
+
+// JobPlan describes what next run does.
+type JobPlan struct {
+	Full   bool                // full archive is to be created
+	Base   *JobArchiveFullItem // full archive item diff (or incremental) archive is created for (nil for full archive)
+	Reason string              // why this kind of archive is chosen
+	Rules  []JobPlanRule       // rules checked (empty if decision was made without them: forced or no full archives)
+
+	Cleanup []string // archives cleanup would delete
+	NoSpace string   // why new archive does not fit into space limits even after cleanup ("" if it fits)
+
+	Files         int     // files to pack
+	SourceSize    int64   // uncompressed size of files to pack
+	Ratio         float64 // compression ratio of latest full archive (0 = unknown)
+	EstimatedSize int64   // estimated archive size (uncompressed size if ratio is unknown)
+}
+
+// Kind returns suffix of archive to be created.
+func (plan *JobPlan) Kind(js *JobSettings) string {
+	if plan.Full {
+		return js.FullSuffix
+	}
+
+	if js.Mode == "incremental" {
+		return js.IncSuffix
+	}
+
+	return js.DiffSuffix
+}
+
+// PlanArchive decides which archive to create next: full or diff (incremental) one. Decision is based
+// on scanned archives list, settings and runtime options only. extension = current archiver extension.
+func PlanArchive(ja *JobArchive, js *JobSettings, options JobOptions, extension string) (JobPlan, error) {
+	plan := JobPlan{Rules: make([]JobPlanRule, 0)}
+
+	if options.ForceFull {
+		plan.Full = true
+		plan.Reason = "Full archive was forced"
+
+		return plan, nil
+	}
+
+	if options.ForceDiff {
+		if len(ja.FullItemList) == 0 {
+			return plan, errors.New("can not force differential backup because no full backups found")
+		}
+
+		plan.Base = &ja.FullItemList[len(ja.FullItemList)-1]
+		plan.Reason = "Diff archive was forced"
+
+		return plan, nil
+	}
+
+	if len(ja.FullItemList) == 0 {
+		//no full archives at all, create one unconditionally
+		plan.Full = true
+		plan.Reason = "No full archives found. Creating one."
+
+		return plan, nil
+	}
+
+	//check diffs for the last one full-arch
+	last_full_arch := &ja.FullItemList[len(ja.FullItemList)-1]
+	diff_count := len(last_full_arch.DiffItemList)
+
+	//diffs can be created only by same archiver as full one
+	plan.Rules = append(plan.Rules, JobPlanRule{
+		Setting:   "archiver",
+		Value:     last_full_arch.File.Name,
+		Threshold: "*" + extension,
+		Triggered: !strings.HasSuffix(last_full_arch.File.Name, extension),
+		Message: fmt.Sprintf(
+			"Last full archive was created with another archiver (current is %s). Creating full archive.", js.Archiver,
+		),
+	})
+
+	//check max count
+	plan.Rules = append(plan.Rules, JobPlanRule{
+		Setting:   "max_diff_count",
+		Value:     fmt.Sprint(diff_count),
+		Threshold: fmt.Sprint(js.MaxDiffCount),
+		Triggered: diff_count >= js.MaxDiffCount,
+		Message: fmt.Sprintf(
+			"Diff archives count (%d) exceeds maximum (%d). Creating full archive.", diff_count, js.MaxDiffCount,
+		),
+	})
+
+	//check max total size (in percents!)
+	rule := JobPlanRule{
+		Setting:   "max_total_diff_size_percent",
+		Value:     fmt.Sprintf("%d%%", last_full_arch.TotalDiffSizePercent),
+		Threshold: "not set",
+	}
+
+	if js.MaxTotalDiffSizePercent > 0 {
+		rule.Threshold = fmt.Sprintf("%d%%", js.MaxTotalDiffSizePercent)
+		rule.Triggered = last_full_arch.TotalDiffSizePercent >= js.MaxTotalDiffSizePercent
+		rule.Message = fmt.Sprintf(
+			"Diff archives total size (%d%% of full archive) exceeds maximum (%d%%). Creating full archive.",
+			last_full_arch.TotalDiffSizePercent, js.MaxTotalDiffSizePercent,
+		)
+	}
+
+	plan.Rules = append(plan.Rules, rule)
+
+	//check last diff size (in percents!)
+	rule = JobPlanRule{
+		Setting:   "max_diff_size_percent",
+		Value:     "no diffs",
+		Threshold: "not set",
+	}
+
+	if diff_count > 0 {
+		last_diff_item := last_full_arch.DiffItemList[diff_count-1]
+		rule.Value = fmt.Sprintf("%d%%", last_diff_item.DiffSizePercent)
+
+		if js.MaxDiffSizePercent > 0 {
+			rule.Triggered = last_diff_item.DiffSizePercent >= js.MaxDiffSizePercent
+			rule.Message = fmt.Sprintf(
+				"Last diff archive size (%d%% of full archive) exceeds maximum (%d%%). Creating full archive.",
+				last_diff_item.DiffSizePercent, js.MaxDiffSizePercent,
+			)
+		}
+	}
+
+	if js.MaxDiffSizePercent > 0 {
+		rule.Threshold = fmt.Sprintf("%d%%", js.MaxDiffSizePercent)
+	}
+
+	plan.Rules = append(plan.Rules, rule)
+
+	//first triggered rule explains decision
+	for _, rule := range plan.Rules {
+		if rule.Triggered {
+			plan.Full = true
+			plan.Reason = rule.Message
+
+			return plan, nil
+		}
+	}
+
+	plan.Base = last_full_arch
+	plan.Reason = fmt.Sprintf("Creating %s archive for %s", js.Mode, last_full_arch.File.Name)
+
+	return plan, nil
+}
+
+// cleanupItems returns full archives (deleted with their diffs) to be removed according to retention
+// settings. Pinned archives are never removed. new_full = new full archive is added to list before cleanup.
+func cleanupItems(ja *JobArchive, js *JobSettings, new_full bool) []*JobArchiveFullItem {
+	list := make([]*JobArchiveFullItem, 0)
+
+	times := make([]time.Time, 0, len(ja.FullItemList)+1)
+	for index := range ja.FullItemList {
+		times = append(times, ja.FullItemList[index].File.Time)
+	}
+
+	if new_full {
+		times = append(times, time.Now())
+	}
+
+	//newest max_full_count archives are kept anyway
+	keep := make([]bool, len(times))
+	for i := max(len(times)-js.MaxFullCount, 0); i < len(times); i++ {
+		keep[i] = true
+	}
+
+	if js.Retention.IsSet() {
+		for i, retained := range js.Retention.keep(times, js.TimestampLocation()) {
+			keep[i] = keep[i] || retained
+		}
+	}
+
+	for i := range ja.FullItemList {
+		if keep[i] || ja.FullItemList[i].IsPinned() {
+			continue
+		}
+
+		if js.KeepAtLeast > 0 && ja.FullItemList[i].File.Age < js.KeepAtLeast {
+			continue
+		}
+
+		list = append(list, &ja.FullItemList[i])
+	}
+
+	return list
+}
+
+// Plan tells what next run would do: which archive would be created and why, which archives would be
+// deleted by cleanup and estimated archive size. Nothing is changed. Job should be created with
+// Options.DryRun for archives directory and log file not to be created as well.
+func (job *Job) Plan() (plan JobPlan, err error) {
+	if err = job.ScanArchive(false); err != nil {
+		return plan, err
+	}
+
+	if plan, err = PlanArchive(&job.Archive, &job.Settings, job.Options, job.Archiver.Extension()); err != nil {
+		return plan, err
+	}
+
+	//cleanup before run does not see new archive
+	new_full := plan.Full && job.Settings.Cleanup == "after"
+	full_items := cleanupItems(&job.Archive, &job.Settings, new_full)
+
+	for _, full_item := range full_items {
+		plan.Cleanup = append(plan.Cleanup, full_item.File.Name)
+
+		for _, diff_item := range full_item.DiffItemList {
+			plan.Cleanup = append(plan.Cleanup, diff_item.File.Name)
+		}
+	}
+
+	thinned := thinDiffs(&job.Archive, &job.Settings, full_items, new_full)
+
+	for _, file := range thinned {
+		plan.Cleanup = append(plan.Cleanup, file.Name)
+	}
+
+	if err = job.estimateArchive(&plan); err != nil {
+		return plan, err
+	}
+
+	//oldest archives deleted to meet space limits (new archive included)
+	if job.Settings.HasSpaceLimits() {
+		free, err := job.freeSpace(plan.EstimatedSize)
+		if err != nil {
+			return plan, err
+		}
+
+		quota_items, reason := quotaCleanupItems(&job.Archive, &job.Settings, full_items, thinned, plan.EstimatedSize, free)
+
+		for _, full_item := range quota_items {
+			plan.Cleanup = append(plan.Cleanup, full_item.File.Name)
+
+			for _, diff_item := range full_item.DiffItemList {
+				if !slices.Contains(thinned, diff_item.File) {
+					plan.Cleanup = append(plan.Cleanup, diff_item.File.Name)
+				}
+			}
+		}
+
+		plan.NoSpace = reason
+	}
+
+	return plan, nil
+}
+
+// calculates files count and size to be packed by planned archive
+func (job *Job) estimateArchive(plan *JobPlan) error {
+	source, err := job.ScanSource()
+	if err != nil {
+		return fmt.Errorf("error scanning directory: %w", err)
+	}
+
+	var files []string
+
+	if plan.Full {
+		files = make([]string, 0, len(source))
+
+		for rel_path := range source {
+			files = append(files, rel_path)
+		}
+	} else {
+		//differential archive has changes since full one, incremental - since latest archive
+		chain := []*JobArchiveFile{plan.Base.File}
+		if job.Settings.Mode == "incremental" {
+			chain = plan.Base.Chain(plan.Base.LastFile())
+		}
+
+		state, err := job.chainState(chain)
+		if err != nil {
+			return fmt.Errorf("error reading previous archives: %w", err)
+		}
+
+		files = changedSourceFiles(source, state)
+	}
+
+	plan.Files = len(files)
+	for _, rel_path := range files {
+		plan.SourceSize += source[rel_path].Size
+	}
+
+	//compression ratio from latest full archive contents
+	if len(job.Archive.FullItemList) > 0 {
+		full_file := job.Archive.FullItemList[len(job.Archive.FullItemList)-1].File
+
+		if full_file.Manifest != nil && strings.HasSuffix(full_file.Name, job.Archiver.Extension()) {
+			var packed_size int64
+			for _, file := range full_file.Manifest.Files {
+				packed_size += file.Size
+			}
+
+			if packed_size > 0 {
+				plan.Ratio = float64(full_file.Size) / float64(packed_size)
+			}
+		}
+	}
+
+	plan.EstimatedSize = plan.SourceSize
+	if plan.Ratio > 0 {
+		plan.EstimatedSize = int64(float64(plan.SourceSize) * plan.Ratio)
+	}
+
+	return nil
+}
+
+// logs what archive would be created and how (dry run). plan should be estimated already. Returns archive filename.
+func (job *Job) dryRunArchive(plan JobPlan) (string, error) {
+	archive_path := job.getArchiveName(plan.Kind(&job.Settings))
+	job.Log("Dry run: archive would be created: %s", archive_path)
+	job.Log("Files to pack: %d, size: %s", plan.Files, mttools.FormatFileSize(plan.SourceSize))
+
+	if command_lines, ok := job.Archiver.(ArchiverCommandLines); ok {
+		var base_path string
+		from_list := !plan.Full && job.Settings.Mode == "incremental"
+
+		if !plan.Full {
+			base_path = plan.Base.File.Path
+		}
+
+		for _, line := range command_lines.CommandLines(TempArchiveFilename(archive_path), base_path, from_list) {
+			job.Log("Dry run: command line: %s", line)
+		}
+	}
+
+	return filepath.Base(archive_path), nil
+}
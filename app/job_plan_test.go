@@ -0,0 +1,246 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPlanArchive(t *testing.T) {
+	tests := []struct {
+		name      string
+		specs     []testArchiveSpec
+		setup     func(js *JobSettings)
+		options   JobOptions
+		extension string //current archiver extension ("" = tar.zst)
+		full      bool
+		rule      string //setting of triggered rule ("" = no rule triggered)
+		fails     bool
+	}{
+		{name: "no archives", full: true},
+		{
+			name:    "forced full",
+			specs:   []testArchiveSpec{{kind: "F", days: 1, size: 100}},
+			options: JobOptions{ForceFull: true},
+			full:    true,
+		},
+		{
+			name:    "forced diff",
+			specs:   []testArchiveSpec{{kind: "F", days: 1, size: 100}, {kind: "D", days: 0.5, size: 500}},
+			options: JobOptions{ForceDiff: true},
+		},
+		{
+			name:    "forced diff without full",
+			options: JobOptions{ForceDiff: true},
+			fails:   true,
+		},
+		{
+			name:  "diff",
+			specs: []testArchiveSpec{{kind: "F", days: 2, size: 100}, {kind: "D", days: 1, size: 10}},
+		},
+		{
+			name:      "another archiver",
+			specs:     []testArchiveSpec{{kind: "F", days: 1, size: 100}},
+			extension: ".7z",
+			full:      true,
+			rule:      "archiver",
+		},
+		{
+			name:  "max_diff_count",
+			specs: []testArchiveSpec{{kind: "F", days: 3, size: 100}, {kind: "D", days: 2, size: 1}, {kind: "D", days: 1, size: 1}},
+			setup: func(js *JobSettings) { js.MaxDiffCount = 2 },
+			full:  true,
+			rule:  "max_diff_count",
+		},
+		{
+			name:  "max_diff_count not reached",
+			specs: []testArchiveSpec{{kind: "F", days: 3, size: 100}, {kind: "D", days: 2, size: 1}},
+			setup: func(js *JobSettings) { js.MaxDiffCount = 2 },
+		},
+		{
+			name:  "max_total_diff_size_percent",
+			specs: []testArchiveSpec{{kind: "F", days: 3, size: 100}, {kind: "D", days: 2, size: 30}, {kind: "D", days: 1, size: 30}},
+			setup: func(js *JobSettings) { js.MaxTotalDiffSizePercent = 50 },
+			full:  true,
+			rule:  "max_total_diff_size_percent",
+		},
+		{
+			name:  "max_total_diff_size_percent not set",
+			specs: []testArchiveSpec{{kind: "F", days: 3, size: 100}, {kind: "D", days: 2, size: 30}, {kind: "D", days: 1, size: 30}},
+		},
+		{
+			name:  "max_diff_size_percent",
+			specs: []testArchiveSpec{{kind: "F", days: 2, size: 100}, {kind: "D", days: 1, size: 60}},
+			setup: func(js *JobSettings) { js.MaxDiffSizePercent = 50 },
+			full:  true,
+			rule:  "max_diff_size_percent",
+		},
+		{
+			name:  "max_diff_size_percent not set",
+			specs: []testArchiveSpec{{kind: "F", days: 2, size: 100}, {kind: "D", days: 1, size: 600}},
+			setup: func(js *JobSettings) { js.MaxDiffSizePercent = 0 },
+		},
+		{
+			name:  "diffs of older full are not counted",
+			specs: []testArchiveSpec{{kind: "F", days: 4, size: 100}, {kind: "D", days: 3, size: 1}, {kind: "D", days: 2, size: 1}, {kind: "F", days: 1, size: 100}},
+			setup: func(js *JobSettings) { js.MaxDiffCount = 2 },
+		},
+	}
+
+	for _, test := range tests {
+		job := newTestJob(t, test.setup)
+		addTestArchives(t, job, test.specs)
+
+		extension := test.extension
+		if extension == "" {
+			extension = job.Archiver.Extension()
+		}
+
+		plan, err := PlanArchive(&job.Archive, &job.Settings, test.options, extension)
+
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: error expected", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if plan.Full != test.full {
+			t.Errorf("%s: full = %v, expected %v (%s)", test.name, plan.Full, test.full, plan.Reason)
+		}
+
+		//diff is based on newest full archive
+		if !plan.Full && plan.Base != &job.Archive.FullItemList[len(job.Archive.FullItemList)-1] {
+			t.Errorf("%s: diff is not based on newest full archive", test.name)
+		}
+
+		rule := ""
+		for _, plan_rule := range plan.Rules {
+			if plan_rule.Triggered {
+				rule = plan_rule.Setting
+				break
+			}
+		}
+
+		if rule != test.rule {
+			t.Errorf("%s: triggered rule '%s', expected '%s'", test.name, rule, test.rule)
+		}
+	}
+}
+
+// returns indexes of names in list
+func testNameIndexes(names []string, list []string) []int {
+	indexes := make([]int, 0)
+
+	for index, name := range names {
+		if slices.Contains(list, name) {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes
+}
+
+func TestCleanupItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		specs    []testArchiveSpec
+		setup    func(js *JobSettings)
+		new_full bool
+		expected []int //indexes of deleted full archives in specs
+	}{
+		{
+			name:     "max_full_count",
+			specs:    []testArchiveSpec{{kind: "F", days: 4}, {kind: "D", days: 3.5}, {kind: "F", days: 3}, {kind: "F", days: 2}, {kind: "F", days: 1}},
+			setup:    func(js *JobSettings) { js.MaxFullCount = 2 },
+			expected: []int{0, 2},
+		},
+		{
+			name:     "new full archive counted",
+			specs:    []testArchiveSpec{{kind: "F", days: 3}, {kind: "F", days: 2}, {kind: "F", days: 1}},
+			setup:    func(js *JobSettings) { js.MaxFullCount = 2 },
+			new_full: true,
+			expected: []int{0, 1},
+		},
+		{
+			name:     "pinned full archive",
+			specs:    []testArchiveSpec{{kind: "F", days: 4, pinned: true}, {kind: "F", days: 3}, {kind: "F", days: 2}, {kind: "F", days: 1}},
+			setup:    func(js *JobSettings) { js.MaxFullCount = 2 },
+			expected: []int{1},
+		},
+		{
+			name:     "pinned diff keeps its full archive",
+			specs:    []testArchiveSpec{{kind: "F", days: 4}, {kind: "D", days: 3.5, pinned: true}, {kind: "F", days: 3}, {kind: "F", days: 2}, {kind: "F", days: 1}},
+			setup:    func(js *JobSettings) { js.MaxFullCount = 2 },
+			expected: []int{2},
+		},
+		{
+			name:     "keep_at_least",
+			specs:    []testArchiveSpec{{kind: "F", days: 20}, {kind: "F", days: 5}, {kind: "F", days: 3}, {kind: "F", days: 1}},
+			setup:    func(js *JobSettings) { js.MaxFullCount = 1; js.KeepAtLeast = 10 },
+			expected: []int{0},
+		},
+		{
+			name:  "retention keeps archives in addition to max_full_count",
+			specs: []testArchiveSpec{{kind: "F", days: 40}, {kind: "F", days: 3}, {kind: "F", days: 2}, {kind: "F", days: 1}},
+			setup: func(js *JobSettings) {
+				js.MaxFullCount = 1
+				js.Retention.KeepDaily = 3
+			},
+			expected: []int{0},
+		},
+		{
+			name:     "nothing to delete",
+			specs:    []testArchiveSpec{{kind: "F", days: 2}, {kind: "F", days: 1}},
+			expected: []int{},
+		},
+	}
+
+	for _, test := range tests {
+		job := newTestJob(t, test.setup)
+		names := addTestArchives(t, job, test.specs)
+
+		deleted := make([]string, 0)
+		for _, full_item := range cleanupItems(&job.Archive, &job.Settings, test.new_full) {
+			deleted = append(deleted, full_item.File.Name)
+		}
+
+		got := testNameIndexes(names, deleted)
+
+		if !slices.Equal(got, test.expected) {
+			t.Errorf("%s: deleted %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestPlanWithoutSideEffects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "src")
+	writeTestFiles(t, path, map[string]string{"a.txt": "first file", DefaultSettingsFilename: `{"archiver": "tar.zst"}`})
+
+	//same options as plan command uses
+	job, err := NewJob(path, JobOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.Close()
+
+	plan, err := job.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !plan.Full || plan.Files == 0 {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+
+	if _, err := os.Stat(job.Settings.ArchivesPath); !os.IsNotExist(err) {
+		t.Errorf("archives directory is created by planning: %v", err)
+	}
+}
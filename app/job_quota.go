@@ -0,0 +1,207 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mitoteam/mttools"
+)
+
+// size with optional unit: 500GB, 1.5T, 100m, 1024
+var sizeRegexp = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([KMGT]?)B?$`)
+
+// parses human readable size. Binary units are used: 1KB = 1024 bytes. Empty value = 0 (not set).
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+
+	if value == "" {
+		return 0, nil
+	}
+
+	matches := sizeRegexp.FindStringSubmatch(strings.ToUpper(value))
+	if matches == nil {
+		return 0, fmt.Errorf("wrong size: %s (examples: 1024, 100MB, 1.5TB)", value)
+	}
+
+	number, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("wrong size: %s", value)
+	}
+
+	multiplier := int64(1)
+	if matches[2] != "" {
+		for range strings.Index("KMGT", matches[2]) + 1 {
+			multiplier *= 1024
+		}
+	}
+
+	return int64(number * float64(multiplier)), nil
+}
+
+// HasSpaceLimits checks if 'max_archives_total_size' or 'min_free_space' is set.
+func (js *JobSettings) HasSpaceLimits() bool {
+	return js.maxArchivesTotalSize > 0 || js.minFreeSpace > 0
+}
+
+// quotaCleanupItems returns oldest full archives (with their diffs) to be deleted to meet
+// 'max_archives_total_size' and 'min_free_space' settings. Archives already chosen for deletion by
+// other rules (deleted full items, thinned diffs) are counted as deleted. reserve = space needed for
+// new archive, free = free space on archives filesystem. Newest full archive with its diffs and pinned
+// archives are always kept. Returns reason if limits can not be met even after deletion ("" if they are met).
+func quotaCleanupItems(
+	ja *JobArchive, js *JobSettings, deleted []*JobArchiveFullItem, thinned []*JobArchiveFile, reserve int64, free int64,
+) ([]*JobArchiveFullItem, string) {
+	list := make([]*JobArchiveFullItem, 0)
+
+	//item size without already thinned diffs
+	item_size := func(full_item *JobArchiveFullItem) int64 {
+		size := full_item.File.Size
+
+		for _, diff_item := range full_item.DiffItemList {
+			if !slices.Contains(thinned, diff_item.File) {
+				size += diff_item.File.Size
+			}
+		}
+
+		return size
+	}
+
+	var total int64
+	for index := range ja.FilesList {
+		total += ja.FilesList[index].Size
+	}
+
+	//space released by other rules
+	for _, full_item := range deleted {
+		total -= item_size(full_item)
+		free += item_size(full_item)
+	}
+
+	for _, file := range thinned {
+		total -= file.Size
+		free += file.Size
+	}
+
+	reason := func() string {
+		if js.maxArchivesTotalSize > 0 && total+reserve > js.maxArchivesTotalSize {
+			return fmt.Sprintf(
+				"archives total size %s exceeds max_archives_total_size %s",
+				mttools.FormatFileSize(total+reserve), mttools.FormatFileSize(js.maxArchivesTotalSize),
+			)
+		}
+
+		if js.minFreeSpace > 0 && free-reserve < js.minFreeSpace {
+			return fmt.Sprintf(
+				"free space %s is less than min_free_space %s",
+				mttools.FormatFileSize(max(free-reserve, 0)), mttools.FormatFileSize(js.minFreeSpace),
+			)
+		}
+
+		return ""
+	}
+
+	//oldest archives go first, newest full archive is never deleted
+	for index := 0; index < len(ja.FullItemList)-1 && reason() != ""; index++ {
+		full_item := &ja.FullItemList[index]
+
+		if slices.Contains(deleted, full_item) || full_item.IsPinned() {
+			continue
+		}
+
+		size := item_size(full_item)
+		total -= size
+		free += size
+
+		list = append(list, full_item)
+	}
+
+	return list, reason()
+}
+
+// returns free space on archives filesystem (0 if 'min_free_space' is not set and reserve is not needed).
+// Closest existing parent directory is checked if archives directory does not exist yet.
+func (job *Job) freeSpace(reserve int64) (int64, error) {
+	if job.Settings.minFreeSpace == 0 && reserve == 0 {
+		return 0, nil
+	}
+
+	path := job.Settings.ArchivesPath
+	for !mttools.IsDirExists(path) && filepath.Dir(path) != path {
+		path = filepath.Dir(path)
+	}
+
+	free, err := diskFreeSpace(path)
+	if err != nil {
+		return 0, &os.PathError{Op: "free space", Path: path, Err: err}
+	}
+
+	return free, nil
+}
+
+// makes sure planned archive fits into space limits: oldest archives are deleted before creating it
+// (listed only in dry run). Returns *SpaceError if there is not enough space even after that. Archives
+// are rescanned after deletion, so plan.Base is updated to point into new list.
+func (job *Job) ensureSpace(plan *JobPlan) ([]string, error) {
+	deleted := make([]string, 0)
+
+	free, err := job.freeSpace(plan.EstimatedSize)
+	if err != nil {
+		return deleted, err
+	}
+
+	full_items, reason := quotaCleanupItems(&job.Archive, &job.Settings, nil, nil, plan.EstimatedSize, free)
+	if reason != "" {
+		return deleted, &SpaceError{Required: plan.EstimatedSize, Reason: reason}
+	}
+
+	if len(full_items) == 0 {
+		return deleted, nil
+	}
+
+	job.Log("Deleting old archives to free space for new archive (about %s)", mttools.FormatFileSize(plan.EstimatedSize))
+
+	for _, full_item := range full_items {
+		if err := job.context().Err(); err != nil {
+			return deleted, err
+		}
+
+		//deleted permanently: trash does not free space
+		if !job.Options.DryRun {
+			if err := full_item.Unlink(); err != nil {
+				return deleted, err
+			}
+		}
+
+		deleted = append(deleted, full_item.File.Name)
+		for _, diff_item := range full_item.DiffItemList {
+			deleted = append(deleted, diff_item.File.Name)
+		}
+	}
+
+	if job.Options.DryRun {
+		for _, name := range deleted {
+			job.Log("Dry run: would delete %s", name)
+		}
+
+		//cleanup should not plan to delete them again
+		job.dryRunDelete(deleted)
+	}
+
+	if err := job.ScanArchive(false); err != nil {
+		return deleted, err
+	}
+
+	//newest full archive is never deleted, so diff base is always found
+	if plan.Base != nil {
+		if plan.Base = job.Archive.FindItem(plan.Base.File); plan.Base == nil {
+			return deleted, fmt.Errorf("full archive for new diff archive was deleted to free space")
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,296 @@
+package app
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCleanupThinningAndQuota(t *testing.T) {
+	day := 24 * time.Hour
+	now := time.Now()
+
+	for _, trash_days := range []int{0, 7} {
+		job := newTestJob(t, func(js *JobSettings) {
+			js.KeepDiffsForLatestFulls = 1
+			js.MaxArchivesTotalSize = "150"
+			js.TrashRetentionDays = trash_days
+		})
+
+		full := job.Settings.FullSuffix
+		diff := job.Settings.DiffSuffix
+
+		old_full := addTestArchive(t, job, full, now.Add(-30*day), 100)
+		thinned := []string{
+			addTestArchive(t, job, diff, now.Add(-29*day), 10),
+			addTestArchive(t, job, diff, now.Add(-28*day), 10),
+		}
+		old_last_diff := addTestArchive(t, job, diff, now.Add(-27*day), 10)
+		new_full := addTestArchive(t, job, full, now.Add(-10*day), 100)
+		new_diff := addTestArchive(t, job, diff, now.Add(-9*day), 10)
+
+		//older diffs are thinned out, then old full archive is deleted to meet size limit
+		result, err := job.cleanup(false)
+		if err != nil {
+			t.Fatalf("trash_retention_days=%d: %s", trash_days, err)
+		}
+
+		expected := sortedCopy(append(slices.Clone(thinned), old_full, old_last_diff))
+		if got := sortedCopy(result.Deleted); !slices.Equal(got, expected) {
+			t.Errorf("trash_retention_days=%d: deleted %v, expected %v", trash_days, got, expected)
+		}
+
+		if got := listTestArchives(t, job, job.Settings.ArchivesPath); !slices.Equal(got, sortedCopy([]string{new_full, new_diff})) {
+			t.Errorf("trash_retention_days=%d: archives left %v", trash_days, got)
+		}
+
+		//thinned diffs are in trash, quota deletes permanently
+		if trash_days > 0 {
+			if got := listTestArchives(t, job, job.Settings.TrashPath()); !slices.Equal(got, sortedCopy(thinned)) {
+				t.Errorf("trash_retention_days=%d: trash contains %v, expected %v", trash_days, got, thinned)
+			}
+		}
+	}
+}
+
+func TestUnlinkMissingFile(t *testing.T) {
+	job := newTestJob(t, nil)
+
+	file := &JobArchiveFile{Path: filepath.Join(job.Settings.ArchivesPath, "missing.tar.zst")}
+
+	if err := file.Unlink(); err != nil {
+		t.Errorf("deleting missing archive: %s", err)
+	}
+}
+
+func TestDryRunQuotaDeletedOnce(t *testing.T) {
+	day := 24 * time.Hour
+	now := time.Now()
+
+	job := newTestJob(t, func(js *JobSettings) {
+		js.MaxArchivesTotalSize = "150"
+	})
+
+	full := job.Settings.FullSuffix
+	diff := job.Settings.DiffSuffix
+
+	old_full := addTestArchive(t, job, full, now.Add(-3*day), 100)
+	old_diff := addTestArchive(t, job, diff, now.Add(-2*day), 10)
+	addTestArchive(t, job, full, now.Add(-day), 100)
+
+	job.Options.DryRun = true
+	job.Options.ForceFull = true
+
+	result, err := job.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//planned by space check before new archive, cleanup should not plan them again
+	if got := sortedCopy(result.Deleted); !slices.Equal(got, sortedCopy([]string{old_full, old_diff})) {
+		t.Errorf("deleted %v", result.Deleted)
+	}
+
+	if got := listTestArchives(t, job, job.Settings.ArchivesPath); len(got) != 3 {
+		t.Errorf("archives deleted in dry run: %v", got)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		err      bool
+	}{
+		{"", 0, false},
+		{" ", 0, false},
+		{"1024", 1024, false},
+		{"100B", 100, false},
+		{"1K", 1024, false},
+		{"1KB", 1024, false},
+		{"1.5m", 1536 * 1024, false},
+		{"2 GB", 2 * 1024 * 1024 * 1024, false},
+		{"1t", 1024 * 1024 * 1024 * 1024, false},
+		{"abc", 0, true},
+		{"-1", 0, true},
+		{"1PB", 0, true},
+		{"1.5.5M", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseSize(test.value)
+
+		if (err != nil) != test.err {
+			t.Errorf("parseSize(%q): error %v, expected error: %v", test.value, err, test.err)
+			continue
+		}
+
+		if got != test.expected {
+			t.Errorf("parseSize(%q) = %d, expected %d", test.value, got, test.expected)
+		}
+	}
+}
+
+func TestQuotaCleanupItems(t *testing.T) {
+	//three fulls with 10 bytes diffs: 120 bytes total, 40 bytes each
+	specs := []testArchiveSpec{
+		{kind: "F", days: 6, size: 30}, {kind: "D", days: 5, size: 10},
+		{kind: "F", days: 4, size: 30}, {kind: "D", days: 3, size: 10},
+		{kind: "F", days: 2, size: 30}, {kind: "D", days: 1, size: 10},
+	}
+
+	tests := []struct {
+		name     string
+		setup    func(js *JobSettings)
+		pinned   []int //indexes of pinned archives in specs
+		deleted  []int //indexes of full archives deleted by other rules
+		thinned  []int //indexes of diffs thinned by other rules
+		reserve  int64
+		free     int64
+		expected []int //indexes of deleted full archives
+		reason   bool  //limits can not be met
+	}{
+		{
+			name:     "no limits",
+			expected: []int{},
+		},
+		{
+			name:     "limit is met",
+			setup:    func(js *JobSettings) { js.MaxArchivesTotalSize = "120" },
+			expected: []int{},
+		},
+		{
+			name:     "max_archives_total_size",
+			setup:    func(js *JobSettings) { js.MaxArchivesTotalSize = "100" },
+			expected: []int{0},
+		},
+		{
+			name:     "reserve for new archive",
+			setup:    func(js *JobSettings) { js.MaxArchivesTotalSize = "100" },
+			reserve:  30,
+			expected: []int{0, 2},
+		},
+		{
+			name:     "newest full archive is kept",
+			setup:    func(js *JobSettings) { js.MaxArchivesTotalSize = "10" },
+			expected: []int{0, 2},
+			reason:   true,
+		},
+		{
+			name:     "pinned archive is kept",
+			setup:    func(js *JobSettings) { js.MaxArchivesTotalSize = "50" },
+			pinned:   []int{1},
+			expected: []int{2},
+			reason:   true,
+		},
+		{
+			name:     "deleted by other rules",
+			setup:    func(js *JobSettings) { js.MaxArchivesTotalSize = "80" },
+			deleted:  []int{0},
+			expected: []int{},
+		},
+		{
+			name:     "thinned diffs",
+			setup:    func(js *JobSettings) { js.MaxArchivesTotalSize = "100" },
+			thinned:  []int{1, 3},
+			expected: []int{},
+		},
+		{
+			name:     "thinned diffs are not counted twice",
+			setup:    func(js *JobSettings) { js.MaxArchivesTotalSize = "75" },
+			thinned:  []int{1},
+			expected: []int{0, 2},
+		},
+		{
+			name:     "min_free_space",
+			setup:    func(js *JobSettings) { js.MinFreeSpace = "100" },
+			free:     30,
+			expected: []int{0, 2},
+		},
+		{
+			name:     "min_free_space is met",
+			setup:    func(js *JobSettings) { js.MinFreeSpace = "100" },
+			free:     100,
+			expected: []int{},
+		},
+	}
+
+	for _, test := range tests {
+		job := newTestJob(t, test.setup)
+
+		test_specs := slices.Clone(specs)
+		for _, index := range test.pinned {
+			test_specs[index].pinned = true
+		}
+
+		names := addTestArchives(t, job, test_specs)
+
+		deleted := make([]*JobArchiveFullItem, 0)
+		thinned := make([]*JobArchiveFile, 0)
+
+		for index := range job.Archive.FullItemList {
+			full_item := &job.Archive.FullItemList[index]
+
+			if slices.Contains(test.deleted, slices.Index(names, full_item.File.Name)) {
+				deleted = append(deleted, full_item)
+			}
+
+			for _, diff_item := range full_item.DiffItemList {
+				if slices.Contains(test.thinned, slices.Index(names, diff_item.File.Name)) {
+					thinned = append(thinned, diff_item.File)
+				}
+			}
+		}
+
+		list, reason := quotaCleanupItems(&job.Archive, &job.Settings, deleted, thinned, test.reserve, test.free)
+
+		got := make([]string, 0)
+		for _, full_item := range list {
+			got = append(got, full_item.File.Name)
+		}
+
+		if indexes := testNameIndexes(names, got); !slices.Equal(indexes, test.expected) {
+			t.Errorf("%s: deleted %v, expected %v", test.name, indexes, test.expected)
+		}
+
+		if (reason != "") != test.reason {
+			t.Errorf("%s: unexpected reason %q", test.name, reason)
+		}
+	}
+}
+
+func TestEnsureSpaceUpdatesBase(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) {
+		js.MaxArchivesTotalSize = "150"
+	})
+
+	names := addTestArchives(t, job, []testArchiveSpec{
+		{kind: "F", days: 3, size: 100}, {kind: "D", days: 2, size: 10}, {kind: "F", days: 1, size: 100},
+	})
+
+	plan, err := PlanArchive(&job.Archive, &job.Settings, job.Options, job.Archiver.Extension())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan.Full {
+		t.Fatalf("diff archive should be planned: %s", plan.Reason)
+	}
+
+	plan.EstimatedSize = 10
+
+	deleted, err := job.ensureSpace(&plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(sortedCopy(deleted), sortedCopy(names[:2])) {
+		t.Errorf("deleted %v", deleted)
+	}
+
+	//base points into rescanned archives list
+	if len(job.Archive.FullItemList) != 1 || plan.Base != &job.Archive.FullItemList[0] || plan.Base.File.Name != names[2] {
+		t.Errorf("diff base is not updated after rescan: %+v", plan.Base)
+	}
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// JobRetentionSettings is grandfather-father-son retention policy for full archives: newest full
+// archive of each day (week, month, year) is kept for given number of latest days (weeks, months, years).
+type JobRetentionSettings struct {
+	KeepDaily   int `yaml:"keep_daily" yaml_comment:"Keep newest full archive of each of this count of latest days (0 = not used)"`
+	KeepWeekly  int `yaml:"keep_weekly" yaml_comment:"Keep newest full archive of each of this count of latest weeks (0 = not used)"`
+	KeepMonthly int `yaml:"keep_monthly" yaml_comment:"Keep newest full archive of each of this count of latest months (0 = not used)"`
+	KeepYearly  int `yaml:"keep_yearly" yaml_comment:"Keep newest full archive of each of this count of latest years (0 = not used)"`
+}
+
+// IsSet checks if at least one retention rule is given.
+func (r *JobRetentionSettings) IsSet() bool {
+	return r.KeepDaily > 0 || r.KeepWeekly > 0 || r.KeepMonthly > 0 || r.KeepYearly > 0
+}
+
+func (r *JobRetentionSettings) check() error {
+	if r.KeepDaily < 0 || r.KeepWeekly < 0 || r.KeepMonthly < 0 || r.KeepYearly < 0 {
+		return newSettingsError("retention values can not be negative")
+	}
+
+	return nil
+}
+
+// marks archives to be kept by retention rules. times should be sorted from oldest to newest,
+// buckets (days, weeks...) are calculated in loc time zone.
+func (r *JobRetentionSettings) keep(times []time.Time, loc *time.Location) []bool {
+	keep := make([]bool, len(times))
+
+	rules := []struct {
+		count  int
+		bucket func(t time.Time) string
+	}{
+		{r.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{r.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{r.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{r.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+
+	for _, rule := range rules {
+		if rule.count <= 0 {
+			continue
+		}
+
+		//newest archive of bucket is met first
+		seen := make(map[string]bool)
+
+		for i := len(times) - 1; i >= 0 && len(seen) < rule.count; i-- {
+			bucket := rule.bucket(times[i].In(loc))
+
+			if !seen[bucket] {
+				seen[bucket] = true
+				keep[i] = true
+			}
+		}
+	}
+
+	return keep
+}
+
+// thinDiffs returns diff archives to be deleted by 'keep_diffs_for_latest_fulls' and 'max_diff_age_days'
+// settings. Full archives from deleted list are not checked (they are deleted with all diffs). Last diff
+// of each full archive and pinned diffs are always kept, as well as all archives kept incremental ones are based on.
+// new_full = new full archive is added to list before cleanup.
+func thinDiffs(ja *JobArchive, js *JobSettings, deleted []*JobArchiveFullItem, new_full bool) []*JobArchiveFile {
+	list := make([]*JobArchiveFile, 0)
+
+	if js.KeepDiffsForLatestFulls == 0 && js.MaxDiffAgeDays == 0 {
+		return list
+	}
+
+	full_count := len(ja.FullItemList)
+	if new_full {
+		full_count++
+	}
+
+	for index := range ja.FullItemList {
+		full_item := &ja.FullItemList[index]
+
+		if len(full_item.DiffItemList) == 0 || slices.Contains(deleted, full_item) {
+			continue
+		}
+
+		old_full := js.KeepDiffsForLatestFulls > 0 && index < full_count-js.KeepDiffsForLatestFulls
+
+		//latest state should stay restorable
+		keep := make(map[string]bool)
+		for _, file := range full_item.Chain(full_item.LastFile()) {
+			keep[file.Path] = true
+		}
+
+		for _, diff_item := range full_item.DiffItemList {
+			too_old := js.MaxDiffAgeDays > 0 && diff_item.File.Age > js.MaxDiffAgeDays
+
+			if (!old_full && !too_old) || diff_item.File.Pin != nil {
+				keep[diff_item.File.Path] = true
+			}
+		}
+
+		//incremental archives can not be restored without archives they are based on
+		for _, diff_item := range full_item.DiffItemList {
+			if keep[diff_item.File.Path] {
+				for _, file := range full_item.Chain(diff_item.File) {
+					keep[file.Path] = true
+				}
+			}
+		}
+
+		for _, diff_item := range full_item.DiffItemList {
+			if !keep[diff_item.File.Path] {
+				list = append(list, diff_item.File)
+			}
+		}
+	}
+
+	return list
+}
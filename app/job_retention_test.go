@@ -0,0 +1,161 @@
+package app
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRetentionKeep(t *testing.T) {
+	date := func(value string) time.Time {
+		result, err := time.ParseInLocation("2006-01-02 15:04", value, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return result
+	}
+
+	times := []time.Time{
+		date("2021-06-15 10:00"), // 0: year 2021
+		date("2022-11-20 10:00"), // 1: november
+		date("2022-12-01 10:00"), // 2: december, week 48
+		date("2022-12-05 09:00"), // 3: week 49
+		date("2022-12-05 18:00"), // 4: same day and week
+		date("2022-12-12 10:00"), // 5: week 50
+		date("2022-12-13 10:00"), // 6: same week
+		date("2022-12-14 10:00"), // 7
+	}
+
+	tests := []struct {
+		name      string
+		retention JobRetentionSettings
+		expected  []int
+	}{
+		{"not set", JobRetentionSettings{}, []int{}},
+		{"daily", JobRetentionSettings{KeepDaily: 3}, []int{5, 6, 7}},
+		{"daily newest of day", JobRetentionSettings{KeepDaily: 4}, []int{4, 5, 6, 7}},
+		{"weekly", JobRetentionSettings{KeepWeekly: 3}, []int{2, 4, 7}},
+		{"monthly", JobRetentionSettings{KeepMonthly: 2}, []int{1, 7}},
+		{"yearly", JobRetentionSettings{KeepYearly: 5}, []int{0, 7}},
+		{"combined", JobRetentionSettings{KeepDaily: 1, KeepMonthly: 2, KeepYearly: 2}, []int{0, 1, 7}},
+		{"more buckets than archives", JobRetentionSettings{KeepDaily: 100}, []int{0, 1, 2, 4, 5, 6, 7}},
+	}
+
+	for _, test := range tests {
+		got := make([]int, 0)
+		for index, keep := range test.retention.keep(times, time.UTC) {
+			if keep {
+				got = append(got, index)
+			}
+		}
+
+		if !slices.Equal(got, test.expected) {
+			t.Errorf("%s: kept %v, expected %v", test.name, got, test.expected)
+		}
+	}
+
+	//buckets are calculated in given time zone: 23:00 UTC is next day in UTC+3
+	late := []time.Time{date("2022-12-05 10:00"), date("2022-12-05 23:00")}
+	daily := JobRetentionSettings{KeepDaily: 2}
+
+	if got := daily.keep(late, time.FixedZone("UTC+3", 3*60*60)); !slices.Equal(got, []bool{true, true}) {
+		t.Errorf("time zone is not used: kept %v", got)
+	}
+}
+
+func TestThinDiffs(t *testing.T) {
+	tests := []struct {
+		name     string
+		specs    []testArchiveSpec
+		setup    func(js *JobSettings)
+		new_full bool
+		deleted  []int //indexes of full archives deleted by other rules
+		expected []int //indexes of thinned diffs
+	}{
+		{
+			name:     "not set",
+			specs:    []testArchiveSpec{{kind: "F", days: 4}, {kind: "D", days: 3}, {kind: "D", days: 2}, {kind: "F", days: 1}},
+			expected: []int{},
+		},
+		{
+			name:     "keep_diffs_for_latest_fulls",
+			specs:    []testArchiveSpec{{kind: "F", days: 6}, {kind: "D", days: 5}, {kind: "D", days: 4}, {kind: "D", days: 3}, {kind: "F", days: 2}, {kind: "D", days: 1.5}, {kind: "D", days: 1}},
+			setup:    func(js *JobSettings) { js.KeepDiffsForLatestFulls = 1 },
+			expected: []int{1, 2},
+		},
+		{
+			name:     "new full archive counted",
+			specs:    []testArchiveSpec{{kind: "F", days: 2}, {kind: "D", days: 1.5}, {kind: "D", days: 1}},
+			setup:    func(js *JobSettings) { js.KeepDiffsForLatestFulls = 1 },
+			new_full: true,
+			expected: []int{1},
+		},
+		{
+			name:     "max_diff_age_days",
+			specs:    []testArchiveSpec{{kind: "F", days: 20}, {kind: "D", days: 15}, {kind: "D", days: 12}, {kind: "D", days: 5}, {kind: "D", days: 2}},
+			setup:    func(js *JobSettings) { js.MaxDiffAgeDays = 10 },
+			expected: []int{1, 2},
+		},
+		{
+			name:     "last diff is kept even if it is too old",
+			specs:    []testArchiveSpec{{kind: "F", days: 20}, {kind: "D", days: 15}, {kind: "D", days: 12}},
+			setup:    func(js *JobSettings) { js.MaxDiffAgeDays = 10 },
+			expected: []int{1},
+		},
+		{
+			name:     "pinned diff",
+			specs:    []testArchiveSpec{{kind: "F", days: 6}, {kind: "D", days: 5, pinned: true}, {kind: "D", days: 4}, {kind: "D", days: 3}, {kind: "F", days: 2}},
+			setup:    func(js *JobSettings) { js.KeepDiffsForLatestFulls = 1 },
+			expected: []int{2},
+		},
+		{
+			name:     "full archive deleted by other rules",
+			specs:    []testArchiveSpec{{kind: "F", days: 6}, {kind: "D", days: 5}, {kind: "D", days: 4}, {kind: "F", days: 2}},
+			setup:    func(js *JobSettings) { js.KeepDiffsForLatestFulls = 1 },
+			deleted:  []int{0},
+			expected: []int{},
+		},
+		{
+			name:     "incremental chain of last archive is kept",
+			specs:    []testArchiveSpec{{kind: "F", days: 6}, {kind: "I", days: 5}, {kind: "I", days: 4}, {kind: "I", days: 3}, {kind: "F", days: 2}},
+			setup:    func(js *JobSettings) { js.KeepDiffsForLatestFulls = 1 },
+			expected: []int{},
+		},
+		{
+			name:     "incrementals before diff",
+			specs:    []testArchiveSpec{{kind: "F", days: 6}, {kind: "I", days: 5}, {kind: "I", days: 4.5}, {kind: "D", days: 4}, {kind: "I", days: 3}, {kind: "F", days: 2}},
+			setup:    func(js *JobSettings) { js.KeepDiffsForLatestFulls = 1 },
+			expected: []int{1, 2},
+		},
+		{
+			name:     "pinned incremental keeps its chain",
+			specs:    []testArchiveSpec{{kind: "F", days: 6}, {kind: "I", days: 5}, {kind: "I", days: 4.5, pinned: true}, {kind: "D", days: 4}, {kind: "I", days: 3}, {kind: "F", days: 2}},
+			setup:    func(js *JobSettings) { js.KeepDiffsForLatestFulls = 1 },
+			expected: []int{},
+		},
+	}
+
+	for _, test := range tests {
+		job := newTestJob(t, test.setup)
+		names := addTestArchives(t, job, test.specs)
+
+		deleted := make([]*JobArchiveFullItem, 0)
+		for index := range job.Archive.FullItemList {
+			full_item := &job.Archive.FullItemList[index]
+
+			if slices.Contains(test.deleted, slices.Index(names, full_item.File.Name)) {
+				deleted = append(deleted, full_item)
+			}
+		}
+
+		thinned := make([]string, 0)
+		for _, file := range thinDiffs(&job.Archive, &job.Settings, deleted, test.new_full) {
+			thinned = append(thinned, file.Name)
+		}
+
+		if got := testNameIndexes(names, thinned); !slices.Equal(got, test.expected) {
+			t.Errorf("%s: thinned %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
@@ -1,24 +1,35 @@
 package app
 
 import (
-	"log"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/mitoteam/mttools"
 )
 
 const DefaultSettingsFilename = ".mtsaver.yml"
 
+// Filename prefix for settings copies stored in archives directory
+const SettingsCopyPrefix = "_mtsaver_settings_"
+
 // Setting for archived folder
 type JobSettings struct {
 	LoadedFromFile bool `yaml:"-"` //ignored in yaml
 
 	ArchivesPath string `yaml:"archives_path" yaml_comment:"Full path to directory to create archives in"`
-	ArchiveName  string `yaml:"archive_name" yaml_comment:"Base archive name (appended with timestamp, suffix and .7z extension)"`
+	ArchiveName  string `yaml:"archive_name" yaml_comment:"Base archive name ({name} placeholder in archive_name_template)"`
 	FullSuffix   string `yaml:"full_suffix" yaml_comment:"Suffix for full archives"`
 	DiffSuffix   string `yaml:"diff_suffix" yaml_comment:"Suffix for differential archives"`
+	IncSuffix    string `yaml:"inc_suffix" yaml_comment:"Suffix for incremental archives"`
 	DateFormat   string `yaml:"date_format" yaml_comment:"Archive filename timestamp format. Don't touch it if you don't understand! Golang's time formatting is a bit crazy https://mttm.ml/go-time-format"`
 
+	ArchiveNameTemplate string `yaml:"archive_name_template" yaml_comment:"Archive filename template (extension is added automatically). Placeholders: {name} = archive_name, {host} = computer name, {date} = timestamp in date_format, {seq} = sequence number to make name unique, {label} = run --label value, {kind} = full_suffix|diff_suffix|inc_suffix. {date} and {kind} are required. Default: {name}_{date}_{kind}"`
+
+	TimestampZone string `yaml:"timestamp_zone" yaml_comment:"Time zone for timestamps in archive filenames: local|utc. Default: local. utc = no jumps on daylight saving time changes. Run 'mtsaver migrate --from-zone <previous value>' to rename existing archives after changing it."`
+
+	Archiver string `yaml:"archiver" yaml_comment:"Archiving engine to create archives with: 7z|tar.zst. Default: 7z. tar.zst = native, no 7-Zip required, keeps unix permissions and symlinks"`
+
 	CompressionLevel int    `yaml:"compression_level" yaml_comment:"7-zip compression level from 0 to 9. Default: 5"`
 	Password         string `yaml:"password" yaml_comment:"Set this to protect .7z file with password."`
 	EncryptFilenames bool   `yaml:"encrypt_filenames" yaml_comment:"Encrypt filenames in .7z archive (used only when 'password' is set)."`
@@ -35,12 +46,30 @@ type JobSettings struct {
 	//List of patterns to be added to archive without compression (works for FULL backups only)
 	SkipCompression []string `yaml:"skip_compression" yaml_comment:"List of patterns for fast adding to archive without compression"`
 
+	//Differential or incremental archives between full ones
+	Mode string `yaml:"mode" yaml_comment:"Archives to create between full ones: differential|incremental. differential = changes since last full archive, incremental = changes since previous archive of any kind. Default: differential"`
+
 	//Run cleanup procedure before or after archive creation (default: after)
 	Cleanup string `yaml_comment:"Cleanup old archives before or after archiving (before|after, default: after)"`
 
+	//Test newly created archive before cleanup
+	VerifyBeforeCleanup bool `yaml:"verify_before_cleanup" yaml_comment:"Test newly created archive before deleting old ones (cleanup: after only). Cleanup is skipped if test fails."`
+
 	MaxFullCount int `yaml:"max_full_count" yaml_comment:"Maximum count of full archives to keep"`
 	KeepAtLeast  int `yaml:"keep_at_least" yaml_comment:"Do not remove full archives if they younger than this count of days"`
 
+	Retention JobRetentionSettings `yaml:"retention" yaml_comment:"Grandfather-father-son retention for full archives (with their diffs). Archives kept by these rules are kept in addition to max_full_count newest ones"`
+
+	//Diff archives thinning for older full archives
+	KeepDiffsForLatestFulls int `yaml:"keep_diffs_for_latest_fulls" yaml_comment:"Keep all diff archives for this count of newest full archives only, older full archives keep their last diff only. 0 = keep all diffs"`
+	MaxDiffAgeDays          int `yaml:"max_diff_age_days" yaml_comment:"Delete diff archives older than this count of days (last diff of each full archive is kept anyway). 0 = not set"`
+
+	//Space limits for archives directory
+	MaxArchivesTotalSize string `yaml:"max_archives_total_size" yaml_comment:"Maximum total size of all archives (examples: 500GB, 1.5TB). Oldest full archives are deleted to meet it, newest one is always kept. Empty = not set"`
+	MinFreeSpace         string `yaml:"min_free_space" yaml_comment:"Minimum free space to leave on archives filesystem (examples: 10GB, 500MB). Oldest full archives are deleted to keep it, newest one is always kept. Empty = not set"`
+
+	TrashRetentionDays int `yaml:"trash_retention_days" yaml_comment:"Move deleted archives to .trash subdirectory of archives directory and purge them after this count of days. 0 = delete archives right away (default)"`
+
 	//Maximum number of diff archives to have after full backup
 	MaxDiffCount int `yaml:"max_diff_count" yaml_comment:"Maximum count of differential archives to create before creating new full archive"`
 
@@ -57,11 +86,17 @@ type JobSettings struct {
 	// Commands to run
 	RunBefore []string `yaml:"run_before" yaml_comment:"List of commands to run before creating archive"`
 
+	RunBeforeFailure string `yaml:"run_before_failure" yaml_comment:"What to do if one of 'run_before' commands fails: abort|continue. abort = do not create archive, continue = create archive and finish with warning. Default: abort"`
+
 	// Log file name
 	LogFilename      string `yaml:"log_filename" yaml_comment:"Name of file to add log messages to."`
 	LogFormat        string `yaml:"log_format" yaml_comment:"Log file format: text|json|no. Default: text. 'no' = disable logging."`
 	LogCommandOutput bool   `yaml:"log_command_output" yaml_comment:"Add commands (from run_before) and 7-Zip output to log file."`
 	LogMaxSize       int64  `yaml:"log_max_size" yaml_comment:"Log file size for it to be rotated. Default: 1Mb."`
+
+	//parsed space limits in bytes (0 = not set)
+	maxArchivesTotalSize int64
+	minFreeSpace         int64
 }
 
 // creates new settings with default values
@@ -84,7 +119,7 @@ func NewJobSettings() JobSettings {
 
 func (js *JobSettings) LoadFromFile(path string) error {
 	if err := mttools.LoadYamlSettingFromFile(path, js); err != nil {
-		return err
+		return &SettingsError{Filename: path, Err: err}
 	}
 
 	js.LoadedFromFile = true
@@ -96,11 +131,40 @@ func (js *JobSettings) SaveToFile(path string, comment string) error {
 	return mttools.SaveYamlSettingToFile(path, Global.AppName+" directory settings file", js)
 }
 
+// SettingsCopyFilename returns path to settings copy in archives directory.
+func (js *JobSettings) SettingsCopyFilename() string {
+	return filepath.Join(js.ArchivesPath, SettingsCopyPrefix+js.ArchiveName+".yml")
+}
+
+// SaveCopy writes effective settings (without password and 'run_before' commands) to archives directory.
+func (js *JobSettings) SaveCopy(source_path string) error {
+	settings_copy := *js
+	settings_copy.Password = ""   //secrets are not saved
+	settings_copy.RunBefore = nil //commands can contain secrets too, restore does not need them
+
+	return mttools.SaveYamlSettingToFile(
+		js.SettingsCopyFilename(),
+		Global.AppName+" settings copy for "+source_path+" (created automatically, password and run_before commands are not saved)",
+		&settings_copy,
+	)
+}
+
+// TimestampLocation returns time zone timestamps in archive filenames are written and parsed in.
+func (js *JobSettings) TimestampLocation() *time.Location {
+	if js.TimestampZone == "utc" {
+		return time.UTC
+	}
+
+	return time.Local
+}
+
 func (js *JobSettings) Print() {
 	mttools.PrintYamlSettings(js)
 }
 
-func (js *JobSettings) ApplyDefaultsAndCheck(job_path string) {
+// ApplyDefaultsAndCheck sets defaults for missing values, applies runtime options and checks settings.
+// Returns *SettingsError if something is wrong.
+func (js *JobSettings) ApplyDefaultsAndCheck(job_path string, options JobOptions) error {
 	//// Set defaults for missing values
 	if js.DateFormat == "" {
 		js.DateFormat = "2006-01-02_15-04-05"
@@ -112,6 +176,14 @@ func (js *JobSettings) ApplyDefaultsAndCheck(job_path string) {
 		js.ArchiveName = name
 	}
 
+	if len(js.ArchiveNameTemplate) == 0 {
+		js.ArchiveNameTemplate = DefaultArchiveNameTemplate
+	}
+
+	if len(js.TimestampZone) == 0 {
+		js.TimestampZone = "local"
+	}
+
 	if len(js.ArchivesPath) == 0 {
 		js.ArchivesPath = filepath.Join(filepath.Dir(job_path), name+"_ARCHIVE")
 	}
@@ -124,6 +196,22 @@ func (js *JobSettings) ApplyDefaultsAndCheck(job_path string) {
 		js.DiffSuffix = "DIFF"
 	}
 
+	if len(js.IncSuffix) == 0 {
+		js.IncSuffix = "INC"
+	}
+
+	if len(js.Mode) == 0 {
+		js.Mode = "differential"
+	}
+
+	if len(js.Archiver) == 0 {
+		js.Archiver = DefaultArchiver
+	}
+
+	if len(js.RunBeforeFailure) == 0 {
+		js.RunBeforeFailure = "abort"
+	}
+
 	if js.CompressionLevel == -1 {
 		js.CompressionLevel = 5
 	}
@@ -133,22 +221,22 @@ func (js *JobSettings) ApplyDefaultsAndCheck(job_path string) {
 	//--------------------------------------
 
 	//turn on solid mode for archives
-	if JobRuntimeOptions.Solid {
+	if options.Solid {
 		js.Solid = true
 	}
 
 	//password
-	if len(JobRuntimeOptions.Password) > 0 {
-		js.Password = JobRuntimeOptions.Password
+	if len(options.Password) > 0 {
+		js.Password = options.Password
 	}
 
 	//encrypt filenames
-	if JobRuntimeOptions.EncryptFilenames {
+	if options.EncryptFilenames {
 		js.EncryptFilenames = true
 	}
 
-	//skip logging
-	if JobRuntimeOptions.NoLog {
+	//skip logging (dry run does not write anything)
+	if options.NoLog || options.DryRun {
 		js.LogFormat = "no"
 	}
 
@@ -156,29 +244,73 @@ func (js *JobSettings) ApplyDefaultsAndCheck(job_path string) {
 	// Do settings checks
 	//--------------------
 
-	if js.FullSuffix == js.DiffSuffix {
-		log.Fatalln("Full suffix should differ from diff suffix")
+	if js.FullSuffix == js.DiffSuffix || js.FullSuffix == js.IncSuffix || js.DiffSuffix == js.IncSuffix {
+		return newSettingsError("full, diff and incremental suffixes should differ from each other")
+	}
+
+	if err := checkArchiveNameTemplate(js.ArchiveNameTemplate); err != nil {
+		return err
+	}
+
+	if js.TimestampZone != "local" && js.TimestampZone != "utc" {
+		return newSettingsError("valid values for 'timestamp_zone' option are 'local', 'utc'")
+	}
+
+	if js.Mode != "differential" && js.Mode != "incremental" {
+		return newSettingsError("valid values for 'mode' option are 'differential', 'incremental'")
+	}
+
+	if _, ok := archiverFactories[js.Archiver]; !ok {
+		return newSettingsError("unknown archiver: %s. Valid values: %s", js.Archiver, strings.Join(ArchiverNames(), ", "))
 	}
 
 	if js.Cleanup == "" {
 		js.Cleanup = "after"
 	} else if js.Cleanup != "before" && js.Cleanup != "after" {
-		log.Fatalln("Valid  values for 'cleanup' option are 'before', 'after'")
+		return newSettingsError("valid values for 'cleanup' option are 'before', 'after'")
+	}
+
+	if js.RunBeforeFailure != "abort" && js.RunBeforeFailure != "continue" {
+		return newSettingsError("valid values for 'run_before_failure' option are 'abort', 'continue'")
 	}
 
 	if js.MaxFullCount < 1 {
-		log.Fatalln("Minimum value for max_full_count is 1")
+		return newSettingsError("minimum value for max_full_count is 1")
+	}
+
+	if err := js.Retention.check(); err != nil {
+		return err
+	}
+
+	if js.TrashRetentionDays < 0 {
+		return newSettingsError("trash_retention_days can not be negative")
+	}
+
+	if js.KeepDiffsForLatestFulls < 0 || js.MaxDiffAgeDays < 0 {
+		return newSettingsError("keep_diffs_for_latest_fulls and max_diff_age_days can not be negative")
+	}
+
+	var err error
+
+	if js.maxArchivesTotalSize, err = parseSize(js.MaxArchivesTotalSize); err != nil {
+		return newSettingsError("max_archives_total_size: %w", err)
+	}
+
+	if js.minFreeSpace, err = parseSize(js.MinFreeSpace); err != nil {
+		return newSettingsError("min_free_space: %w", err)
 	}
 
 	if js.MaxDiffCount < 0 {
-		log.Fatalln("Minimum value for max_diff_count is 0")
+		return newSettingsError("minimum value for max_diff_count is 0")
 	}
 
 	if js.LogFormat != "no" && js.LogFormat != "text" && js.LogFormat != "json" {
-		log.Fatalf("Wrong log format: %s\n", js.LogFormat)
+		return newSettingsError("wrong log format: %s", js.LogFormat)
 	}
 
 	if js.LogMaxSize < 10240 {
-		log.Fatalln("Minimum value for log_size_max is 10240 (10kb)")
+		return newSettingsError("minimum value for log_max_size is 10240 (10kb)")
 	}
+
+	return nil
 }
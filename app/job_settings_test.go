@@ -0,0 +1,100 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSettingsCopy(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) {
+		js.Password = "secret-password"
+		js.RunBefore = []string{"mysqldump --password=secret-command"}
+		js.MaxFullCount = 7
+	})
+
+	if err := job.Settings.SaveCopy(job.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(job.Settings.SettingsCopyFilename())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "secret-password") {
+		t.Error("password is saved to settings copy")
+	}
+
+	if strings.Contains(string(data), "secret-command") {
+		t.Error("run_before commands are saved to settings copy")
+	}
+
+	if job.Settings.Password != "secret-password" || len(job.Settings.RunBefore) != 1 {
+		t.Error("secrets are removed from job settings")
+	}
+
+	settings := NewJobSettings()
+	if err := settings.LoadFromFile(job.Settings.SettingsCopyFilename()); err != nil {
+		t.Fatal(err)
+	}
+
+	if settings.MaxFullCount != 7 || settings.Archiver != "tar.zst" {
+		t.Errorf("settings are not saved: %+v", settings)
+	}
+}
+
+func TestApplyDefaultsAndCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(js *JobSettings)
+		valid bool
+	}{
+		{"defaults", func(js *JobSettings) {}, true},
+		{"same suffixes", func(js *JobSettings) { js.DiffSuffix = "FULL" }, false},
+		{"unknown mode", func(js *JobSettings) { js.Mode = "mirror" }, false},
+		{"unknown archiver", func(js *JobSettings) { js.Archiver = "zip" }, false},
+		{"wrong cleanup", func(js *JobSettings) { js.Cleanup = "never" }, false},
+		{"wrong run_before_failure", func(js *JobSettings) { js.RunBeforeFailure = "ignore" }, false},
+		{"max_full_count", func(js *JobSettings) { js.MaxFullCount = 0 }, false},
+		{"max_diff_count", func(js *JobSettings) { js.MaxDiffCount = -1 }, false},
+		{"log format", func(js *JobSettings) { js.LogFormat = "xml" }, false},
+		{"negative retention", func(js *JobSettings) { js.Retention.KeepWeekly = -1 }, false},
+		{"negative max_diff_age_days", func(js *JobSettings) { js.MaxDiffAgeDays = -1 }, false},
+		{"wrong max_archives_total_size", func(js *JobSettings) { js.MaxArchivesTotalSize = "1 parsec" }, false},
+	}
+
+	for _, test := range tests {
+		settings := NewJobSettings()
+		test.setup(&settings)
+
+		err := settings.ApplyDefaultsAndCheck("/backup/src", JobOptions{})
+
+		if (err == nil) != test.valid {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		if err != nil {
+			if _, ok := err.(*SettingsError); !ok {
+				t.Errorf("%s: %T is not settings error", test.name, err)
+			}
+		}
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	settings := NewJobSettings()
+
+	if err := settings.ApplyDefaultsAndCheck(filepath.FromSlash("/backup/src"), JobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if settings.ArchiveName != "src" || settings.ArchivesPath != filepath.FromSlash("/backup/src_ARCHIVE") {
+		t.Errorf("wrong archives location: %s, %s", settings.ArchivesPath, settings.ArchiveName)
+	}
+
+	if settings.Archiver != DefaultArchiver || settings.Mode != "differential" || settings.Cleanup != "after" {
+		t.Errorf("wrong defaults: %+v", settings)
+	}
+}
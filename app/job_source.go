@@ -0,0 +1,230 @@
+package app
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SourceFile is file found in job directory.
+type SourceFile struct {
+	Path    string      //slash separated, relative to job directory
+	Size    int64       //file size
+	ModTime time.Time   //modification time
+	Mode    fs.FileMode //file mode and permissions
+}
+
+// ScanSource walks job directory and returns all files (directories are not listed)
+// not matching 'exclude' patterns mapped by relative path.
+func (job *Job) ScanSource() (map[string]SourceFile, error) {
+	list := make(map[string]SourceFile)
+
+	err := job.walkSource(func(rel_path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			job.Log("Error reading %s: %s", filepath.Join(job.Path, rel_path), err.Error())
+			return nil
+		}
+
+		//regular files and symlinks only
+		if !info.Mode().IsRegular() && info.Mode()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		list[rel_path] = SourceFile{
+			Path:    rel_path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Mode:    info.Mode(),
+		}
+
+		return nil
+	})
+
+	return list, err
+}
+
+// ScanSourceDirs walks job directory and returns sorted list of its subdirectories (relative,
+// slash separated) not matching 'exclude' patterns.
+func (job *Job) ScanSourceDirs() ([]string, error) {
+	list := make([]string, 0)
+
+	err := job.walkSource(func(rel_path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			list = append(list, rel_path)
+		}
+
+		return nil
+	})
+
+	sort.Strings(list)
+
+	return list, err
+}
+
+// walks job directory calling f for every entry (directories included) not matching 'exclude' patterns.
+// rel_path is relative to job directory and slash separated. Excluded directories are not walked into.
+func (job *Job) walkSource(f func(rel_path string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(job.Path, func(file_path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			//unreadable entries are reported but do not stop scanning
+			job.Log("Error reading %s: %s", file_path, err.Error())
+			return nil
+		}
+
+		if file_path == job.Path {
+			return nil
+		}
+
+		rel_path, err := filepath.Rel(job.Path, file_path)
+		if err != nil {
+			return err
+		}
+		rel_path = filepath.ToSlash(rel_path)
+
+		if matchExcludePatterns(job.Settings.Exclude, rel_path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		return f(rel_path, d)
+	})
+}
+
+// sourceDirs describes job directory subdirectories comparing to base archive.
+type sourceDirs struct {
+	All     []string //all subdirectories
+	New     []string //created since base archive (nil if base archive manifest does not list directories)
+	Deleted []string //deleted since base archive (nil if base archive manifest does not list directories)
+}
+
+// scans job directory subdirectories and compares them to ones listed in base archive manifest
+func (job *Job) scanSourceDirs(base *JobArchiveFile) (dirs sourceDirs, err error) {
+	if dirs.All, err = job.ScanSourceDirs(); err != nil {
+		return dirs, err
+	}
+
+	//manifests written by older versions do not list directories
+	if base != nil && base.Manifest != nil && base.Manifest.Dirs != nil {
+		dirs.New = missingPaths(dirs.All, base.Manifest.Dirs)
+		dirs.Deleted = missingPaths(base.Manifest.Dirs, dirs.All)
+	}
+
+	return dirs, nil
+}
+
+// Returns sorted list of paths from list missing in other one.
+func missingPaths(list []string, other []string) []string {
+	index := make(map[string]bool, len(other))
+	for _, rel_path := range other {
+		index[rel_path] = true
+	}
+
+	missing := make([]string, 0)
+
+	for _, rel_path := range list {
+		if !index[rel_path] {
+			missing = append(missing, rel_path)
+		}
+	}
+
+	sort.Strings(missing)
+
+	return missing
+}
+
+// Returns directories from dirs having no path from files or dirs inside. Archivers create parent
+// directories of packed entries, so only these directories should be packed to be restored.
+func leafDirs(dirs []string, files []string) []string {
+	parents := make(map[string]bool)
+
+	for _, list := range [][]string{dirs, files} {
+		for _, rel_path := range list {
+			for p := path.Dir(rel_path); p != "." && !parents[p]; p = path.Dir(p) {
+				parents[p] = true
+			}
+		}
+	}
+
+	leafs := make([]string, 0)
+
+	for _, dir := range dirs {
+		if !parents[dir] {
+			leafs = append(leafs, dir)
+		}
+	}
+
+	return leafs
+}
+
+// Returns sorted list of source files changed comparing to state (new files included).
+func changedSourceFiles(source map[string]SourceFile, state map[string]ArchiveEntry) []string {
+	list := make([]string, 0)
+
+	for rel_path, file := range source {
+		entry, ok := state[rel_path]
+
+		//symlinks sizes are stored differently by archivers, so they are compared by time only
+		size_changed := entry.Size != file.Size && file.Mode&fs.ModeSymlink == 0
+
+		if !ok || size_changed || !sameModTime(entry.ModTime, file.ModTime) {
+			list = append(list, rel_path)
+		}
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// Returns sorted list of files from state missing in source (deleted since state was saved).
+func deletedSourceFiles(source map[string]SourceFile, state map[string]ArchiveEntry) []string {
+	list := make([]string, 0)
+
+	for rel_path := range state {
+		if _, ok := source[rel_path]; !ok {
+			list = append(list, rel_path)
+		}
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// archivers store modification time with different precision, so less than a second difference is ignored
+func sameModTime(t1 time.Time, t2 time.Time) bool {
+	diff := t1.Sub(t2)
+
+	return diff > -time.Second && diff < time.Second
+}
+
+// checks path (relative to job directory, slash separated) against 'exclude' patterns.
+// Pattern is matched against file name and against whole relative path.
+func matchExcludePatterns(patterns []string, rel_path string) bool {
+	name := path.Base(rel_path)
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "./")
+
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+
+		if matched, _ := path.Match(pattern, rel_path); matched {
+			return true
+		}
+	}
+
+	return false
+}
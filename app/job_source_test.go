@@ -0,0 +1,158 @@
+package app
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestChangedSourceFiles(t *testing.T) {
+	mod_time := time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC)
+
+	source := map[string]SourceFile{
+		"same.txt":      {Path: "same.txt", Size: 10, ModTime: mod_time},
+		"precision.txt": {Path: "precision.txt", Size: 10, ModTime: mod_time.Add(500 * time.Millisecond)},
+		"size.txt":      {Path: "size.txt", Size: 11, ModTime: mod_time},
+		"time.txt":      {Path: "time.txt", Size: 10, ModTime: mod_time.Add(time.Hour)},
+		"new.txt":       {Path: "new.txt", Size: 10, ModTime: mod_time},
+		"link":          {Path: "link", Size: 5, ModTime: mod_time, Mode: fs.ModeSymlink},
+	}
+
+	state := map[string]ArchiveEntry{
+		"same.txt":      {Path: "same.txt", Size: 10, ModTime: mod_time},
+		"precision.txt": {Path: "precision.txt", Size: 10, ModTime: mod_time},
+		"size.txt":      {Path: "size.txt", Size: 10, ModTime: mod_time},
+		"time.txt":      {Path: "time.txt", Size: 10, ModTime: mod_time},
+		"link":          {Path: "link", Size: 0, ModTime: mod_time},
+		"deleted.txt":   {Path: "deleted.txt", Size: 10, ModTime: mod_time},
+	}
+
+	expected := []string{"new.txt", "size.txt", "time.txt"}
+
+	if got := changedSourceFiles(source, state); !slices.Equal(got, expected) {
+		t.Errorf("changed files: %v, expected %v", got, expected)
+	}
+}
+
+func TestScanSource(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) { js.Exclude = []string{"*.tmp", "cache"} })
+
+	writeTestFiles(t, job.Path, map[string]string{
+		"a.txt":           "a",
+		"dir/b.txt":       "b",
+		"dir/skip.tmp":    "excluded",
+		"cache/c.txt":     "excluded",
+		"empty/":          "",
+		"dir/sub/d.txt":   "d",
+		"dir/sub/e.tmp/":  "",
+		"dir/sub/e.tmp/f": "excluded with directory",
+	})
+
+	source, err := job.ScanSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := make([]string, 0)
+	for rel_path := range source {
+		list = append(list, rel_path)
+	}
+	slices.Sort(list)
+
+	if expected := []string{"a.txt", "dir/b.txt", "dir/sub/d.txt"}; !slices.Equal(list, expected) {
+		t.Errorf("scanned files: %v, expected %v", list, expected)
+	}
+}
+
+func TestDeletedSourceFiles(t *testing.T) {
+	source := map[string]SourceFile{
+		"a.txt": {Path: "a.txt"},
+		"new":   {Path: "new"},
+	}
+
+	state := map[string]ArchiveEntry{
+		"a.txt":     {Path: "a.txt"},
+		"z.txt":     {Path: "z.txt"},
+		"dir/b.txt": {Path: "dir/b.txt"},
+	}
+
+	expected := []string{"dir/b.txt", "z.txt"}
+
+	if got := deletedSourceFiles(source, state); !slices.Equal(got, expected) {
+		t.Errorf("deleted files: %v, expected %v", got, expected)
+	}
+}
+
+func TestLeafDirs(t *testing.T) {
+	tests := []struct {
+		dirs     []string
+		files    []string
+		expected []string
+	}{
+		{[]string{"a"}, nil, []string{"a"}},
+		{[]string{"a", "a/b", "a/b/c"}, nil, []string{"a/b/c"}},
+		{[]string{"a", "a/b", "c"}, []string{"a/b/file.txt"}, []string{"c"}},
+		{[]string{"a", "ab"}, []string{"ab/file.txt"}, []string{"a"}},
+		{nil, []string{"file.txt"}, []string{}},
+	}
+
+	for _, test := range tests {
+		if got := leafDirs(test.dirs, test.files); !slices.Equal(got, test.expected) {
+			t.Errorf("leafDirs(%v, %v) = %v, expected %v", test.dirs, test.files, got, test.expected)
+		}
+	}
+}
+
+func TestMissingPaths(t *testing.T) {
+	got := missingPaths([]string{"c", "a", "b"}, []string{"b", "d"})
+
+	if !slices.Equal(got, []string{"a", "c"}) {
+		t.Errorf("missingPaths = %v", got)
+	}
+}
+
+func TestRemoveDeletedDirs(t *testing.T) {
+	job := newTestJob(t, nil)
+	to := t.TempDir()
+
+	for _, dir := range []string{"kept", "gone/deep", "again", "full/file"} {
+		if err := os.MkdirAll(filepath.Join(to, dir), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(to, "full", "file", "data.txt"), []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	chain := []*JobArchiveFile{
+		{Name: "full", Manifest: &JobArchiveManifest{Dirs: []string{"kept", "gone", "gone/deep", "again", "full", "full/file"}}},
+		//old manifest without directories
+		{Name: "inc1"},
+		{Name: "inc2", Manifest: &JobArchiveManifest{
+			Dirs:        []string{"kept"},
+			DeletedDirs: []string{"gone", "gone/deep", "again", "full", "full/file"},
+		}},
+		//"again" was created again later
+		{Name: "inc3", Manifest: &JobArchiveManifest{Dirs: []string{"kept", "again"}}},
+	}
+
+	removed, err := job.removeDeletedDirs(to, chain, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//not empty directories are kept
+	if !slices.Equal(removed, []string{"gone/deep", "gone"}) {
+		t.Errorf("removed %v", removed)
+	}
+
+	for _, dir := range []string{"kept", "again", "full/file"} {
+		if _, err := os.Stat(filepath.Join(to, dir)); err != nil {
+			t.Errorf("directory %s should be kept: %s", dir, err)
+		}
+	}
+}
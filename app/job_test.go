@@ -0,0 +1,670 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// creates job for empty temporary source directory with tar.zst archiver. setup can change settings
+// before defaults are applied and they are checked.
+func newTestJob(t *testing.T, setup func(js *JobSettings)) *Job {
+	t.Helper()
+
+	job := &Job{
+		Path:    filepath.Join(t.TempDir(), "src"),
+		Options: JobOptions{NoLog: true},
+	}
+
+	if err := os.Mkdir(job.Path, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	job.Settings = NewJobSettings()
+	job.Settings.Archiver = "tar.zst"
+
+	if setup != nil {
+		setup(&job.Settings)
+	}
+
+	if err := job.Settings.ApplyDefaultsAndCheck(job.Path, job.Options); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	return job
+}
+
+// archive names with nanoseconds: several archives are created during one second in tests
+func testDateFormat(js *JobSettings) {
+	js.DateFormat = "2006-01-02_15-04-05.000000000"
+}
+
+// writes fake archive file of given kind (full or diff suffix) and size created at archive_time.
+// Returns archive filename.
+func addTestArchive(t *testing.T, job *Job, kind string, archive_time time.Time, size int) string {
+	t.Helper()
+
+	name := job.renderArchiveName(archiveNameValues{
+		Kind: kind,
+		Time: archive_time,
+		Seq:  1,
+		Ext:  job.Archiver.Extension(),
+	})
+
+	path := filepath.Join(job.Settings.ArchivesPath, name)
+
+	//content differs for each archive so diffs are never same
+	content := []byte(strings.Repeat("x", size))
+	copy(content, name)
+
+	if err := os.WriteFile(path, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, archive_time, archive_time); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+// fake archive: kind "F" (full), "D" (diff) or "I" (incremental), age in days, size, pinned
+type testArchiveSpec struct {
+	kind   string
+	days   float64
+	size   int
+	pinned bool
+}
+
+// writes fake archives and scans archives directory. Returns archive filenames in specs order.
+func addTestArchives(t *testing.T, job *Job, specs []testArchiveSpec) []string {
+	t.Helper()
+
+	kinds := map[string]string{"F": job.Settings.FullSuffix, "D": job.Settings.DiffSuffix, "I": job.Settings.IncSuffix}
+	now := time.Now()
+	names := make([]string, len(specs))
+
+	for index, spec := range specs {
+		archive_time := now.Add(-time.Duration(spec.days * float64(24*time.Hour)))
+		names[index] = addTestArchive(t, job, kinds[spec.kind], archive_time, spec.size)
+
+		if spec.pinned {
+			if err := job.pinArchive(job.archivePath(names[index]), "test"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	return names
+}
+
+// lists archive filenames in directory
+func listTestArchives(t *testing.T, job *Job, dir string) []string {
+	t.Helper()
+
+	list, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0)
+	for _, entry := range list {
+		if strings.HasSuffix(entry.Name(), job.Archiver.Extension()) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// returns sorted copy of list
+func sortedCopy(list []string) []string {
+	list = slices.Clone(list)
+	slices.Sort(list)
+
+	return list
+}
+
+// writes files tree to dir: path => content. Paths ending with "/" are directories, content starting
+// with "-> " creates symlink.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+
+		var err error
+
+		switch {
+		case name[len(name)-1] == '/':
+			err = os.MkdirAll(path, 0777)
+		case len(content) > 3 && content[:3] == "-> ":
+			err = os.Symlink(content[3:], path)
+		default:
+			err = os.WriteFile(path, []byte(content), 0666)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// reads files tree in writeTestFiles format
+func readTestTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	tree := make(map[string]string)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+
+		rel_path, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel_path = filepath.ToSlash(rel_path)
+
+		switch {
+		case d.IsDir():
+			tree[rel_path+"/"] = ""
+
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			tree[rel_path] = "-> " + link
+
+		default:
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			tree[rel_path] = string(content)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tree
+}
+
+func TestIncrementalBackupAndRestore(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) {
+		testDateFormat(js)
+		js.Mode = "incremental"
+	})
+
+	writeTestFiles(t, job.Path, map[string]string{
+		"a.txt":     "first file",
+		"dir/b.txt": "second file",
+	})
+
+	states := make([]map[string]string, 0)
+
+	for _, changes := range []map[string]string{
+		nil,
+		{"a.txt": "first file changed"},
+		{"dir/b.txt": "second file changed", "dir/c.txt": "new file"},
+	} {
+		writeTestFiles(t, job.Path, changes)
+
+		if _, err := job.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		states = append(states, readTestTree(t, job.Path))
+	}
+
+	//nothing changed: no archive is created
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(job.Archive.FullItemList) != 1 || len(job.Archive.FullItemList[0].DiffItemList) != 2 {
+		t.Fatalf("one full and two incremental archives expected, found: %d", len(job.Archive.FilesList))
+	}
+
+	//incremental archive has changes since previous one only
+	entries, err := job.Archiver.List(job.Archive.LastFile().Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packed := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.IsDir {
+			packed = append(packed, entry.Path)
+		}
+	}
+
+	slices.Sort(packed)
+
+	if !slices.Equal(packed, []string{"dir/b.txt", "dir/c.txt"}) {
+		t.Errorf("unexpected files in incremental archive: %v", packed)
+	}
+
+	for index, file := range job.Archive.FilesList {
+		if file.IsInc != (index > 0) {
+			t.Errorf("%s: wrong archive kind", file.Name)
+		}
+
+		to := filepath.Join(t.TempDir(), "restored")
+		if _, err := job.Restore(to, &job.Archive.FilesList[index], nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := readTestTree(t, to); !maps.Equal(got, states[index]) {
+			t.Errorf("%s restored:\n%v\nexpected:\n%v", file.Name, got, states[index])
+		}
+	}
+}
+
+func TestDeletedFilesRestore(t *testing.T) {
+	for _, mode := range []string{"differential", "incremental"} {
+		job := newTestJob(t, func(js *JobSettings) {
+			testDateFormat(js)
+			js.Mode = mode
+		})
+
+		writeTestFiles(t, job.Path, map[string]string{
+			"a.txt":     "first file",
+			"dir/b.txt": "second file",
+			"dir/c.txt": "third file",
+		})
+
+		if _, err := job.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		//file deleted and nothing else changed: archive should be created anyway
+		if err := os.Remove(filepath.Join(job.Path, "dir", "b.txt")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := job.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		writeTestFiles(t, job.Path, map[string]string{"d.txt": "new file"})
+
+		if err := os.Remove(filepath.Join(job.Path, "a.txt")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := job.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := job.ScanArchive(false); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(job.Archive.FilesList) != 3 {
+			t.Fatalf("%s: three archives expected, found: %d", mode, len(job.Archive.FilesList))
+		}
+
+		to := filepath.Join(t.TempDir(), "restored")
+		if _, err := job.Restore(to, job.Archive.LastFile(), nil); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := readTestTree(t, job.Path)
+
+		if got := readTestTree(t, to); !maps.Equal(got, expected) {
+			t.Errorf("%s: restored tree:\n%v\nexpected:\n%v", mode, got, expected)
+		}
+	}
+}
+
+func TestRestorePaths(t *testing.T) {
+	job := newTestJob(t, testDateFormat)
+
+	writeTestFiles(t, job.Path, map[string]string{
+		"a.txt":         "first file",
+		"dir/b.txt":     "second file",
+		"dir/sub/c.txt": "third file",
+	})
+
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFiles(t, job.Path, map[string]string{"dir/sub/c.txt": "third file changed"})
+
+	if err := os.Remove(filepath.Join(job.Path, "dir", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		paths    []string
+		expected map[string]string
+	}{
+		{[]string{"a.txt"}, map[string]string{"a.txt": "first file"}},
+		{[]string{"dir"}, map[string]string{"dir/": "", "dir/sub/": "", "dir/sub/c.txt": "third file changed"}},
+		{[]string{"dir/b.txt"}, map[string]string{"dir/": ""}}, //deleted file: only its directory from full archive is left
+	}
+
+	for _, test := range tests {
+		to := filepath.Join(t.TempDir(), "restored")
+		if _, err := job.Restore(to, job.Archive.LastFile(), test.paths); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := readTestTree(t, to); !maps.Equal(got, test.expected) {
+			t.Errorf("%v restored:\n%v\nexpected:\n%v", test.paths, got, test.expected)
+		}
+	}
+}
+
+func TestRestoreFromArchives(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) {
+		testDateFormat(js)
+		js.Mode = "incremental"
+	})
+
+	writeTestFiles(t, job.Path, map[string]string{"a.txt": "first file"})
+
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFiles(t, job.Path, map[string]string{"dir/b.txt": "second file"})
+
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := readTestTree(t, job.Path)
+
+	//source directory is lost, archives directory is moved
+	archives_path := filepath.Join(t.TempDir(), "moved")
+	if err := os.Rename(job.Settings.ArchivesPath, archives_path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.RemoveAll(job.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	restore_job, err := NewJobFromArchives(archives_path, JobOptions{NoLog: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restore_job.Settings.Mode != "incremental" {
+		t.Errorf("settings are not loaded from copy: mode = %s", restore_job.Settings.Mode)
+	}
+
+	if err := restore_job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	to := filepath.Join(t.TempDir(), "restored")
+	if _, err := restore_job.Restore(to, restore_job.Archive.LastFile(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readTestTree(t, to); !maps.Equal(got, expected) {
+		t.Errorf("restored tree:\n%v\nexpected:\n%v", got, expected)
+	}
+}
+
+func TestRestoreFromReadOnlyArchives(t *testing.T) {
+	job := newTestJob(t, testDateFormat)
+	writeTestFiles(t, job.Path, map[string]string{"a.txt": "first file", "dir/b.txt": "second file"})
+
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := readTestTree(t, job.Path)
+
+	//archives on read-only media
+	if err := os.Chmod(job.Settings.ArchivesPath, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(job.Settings.ArchivesPath, 0777) })
+
+	if !isReadOnlyDir(job.Settings.ArchivesPath) {
+		t.Skip("directory permissions are not enforced for this user")
+	}
+
+	//log file and lock can not be written
+	restore_job, err := NewJobFromArchives(job.Settings.ArchivesPath, JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restore_job.Close()
+
+	if err := restore_job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	to := filepath.Join(t.TempDir(), "restored")
+	if _, err := restore_job.Restore(to, restore_job.Archive.LastFile(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readTestTree(t, to); !maps.Equal(got, expected) {
+		t.Errorf("restored tree:\n%v\nexpected:\n%v", got, expected)
+	}
+}
+
+func TestRunBeforeFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix commands are used")
+	}
+
+	for _, failure := range []string{"abort", "continue"} {
+		job := newTestJob(t, func(js *JobSettings) {
+			js.RunBefore = []string{"true", "false"}
+			js.RunBeforeFailure = failure
+		})
+
+		writeTestFiles(t, job.Path, map[string]string{"a.txt": "first file"})
+
+		_, err := job.Run()
+
+		var command_error *CommandError
+		if !errors.As(err, &command_error) || command_error.Command != "false" {
+			t.Errorf("%s: command error expected, got: %v", failure, err)
+		}
+
+		if IsWarning(err) != (failure == "continue") {
+			t.Errorf("%s: wrong error kind: %v", failure, err)
+		}
+
+		if err := job.ScanArchive(false); err != nil {
+			t.Fatal(err)
+		}
+
+		if created := len(job.Archive.FilesList) > 0; created != (failure == "continue") {
+			t.Errorf("%s: archive created: %v", failure, created)
+		}
+	}
+}
+
+func TestRunInterrupted(t *testing.T) {
+	job := newTestJob(t, nil)
+	writeTestFiles(t, job.Path, map[string]string{"a.txt": "first file"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	job.SetContext(ctx)
+
+	_, err := job.Run()
+
+	if !errors.Is(err, context.Canceled) || ExitCode(err) != ExitInterrupted {
+		t.Errorf("interruption error expected, got: %v", err)
+	}
+
+	//unfinished archive is removed
+	list, err := os.ReadDir(job.Settings.ArchivesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range list {
+		if strings.HasPrefix(entry.Name(), job.Settings.ArchiveName+"_") {
+			t.Errorf("archive file is left: %s", entry.Name())
+		}
+	}
+}
+
+func TestStaleTempFilesRemoved(t *testing.T) {
+	job := newTestJob(t, nil)
+	writeTestFiles(t, job.Path, map[string]string{"a.txt": "first file"})
+
+	stale := TempArchiveFilename(job.getArchiveName(job.Settings.FullSuffix))
+	foreign := filepath.Join(job.Settings.ArchivesPath, "other_archive.tar.zst"+TempArchiveSuffix)
+
+	for _, path := range []string{stale, foreign} {
+		if err := os.WriteFile(path, []byte("unfinished"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale temporary archive is not removed: %v", err)
+	}
+
+	if _, err := os.Stat(foreign); err != nil {
+		t.Errorf("temporary file of other job is removed: %v", err)
+	}
+
+	if err := job.ScanArchive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(job.Archive.FilesList) != 1 {
+		t.Errorf("one archive expected, found: %d", len(job.Archive.FilesList))
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) { js.MaxFullCount = 2 })
+	writeTestFiles(t, job.Path, map[string]string{"a.txt": "first file"})
+
+	names := addTestArchives(t, job, []testArchiveSpec{
+		{kind: "F", days: 3, size: 100}, {kind: "D", days: 2, size: 10}, {kind: "F", days: 1, size: 100},
+	})
+
+	stale := TempArchiveFilename(job.getArchiveName(job.Settings.DiffSuffix))
+	if err := os.WriteFile(stale, []byte("unfinished"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	job.Options.DryRun = true
+	job.Options.ForceFull = true
+
+	result, err := job.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Created != "" || !strings.HasSuffix(result.Planned, job.Settings.FullSuffix+job.Archiver.Extension()) {
+		t.Errorf("full archive should be planned only: %+v", result)
+	}
+
+	//new full archive is counted by cleanup
+	if !slices.Contains(result.Deleted, names[0]) {
+		t.Errorf("oldest full archive is not planned for deletion: %v", result.Deleted)
+	}
+
+	if got := listTestArchives(t, job, job.Settings.ArchivesPath); !slices.Equal(got, names) {
+		t.Errorf("archives are changed in dry run: %v", got)
+	}
+
+	if _, err := os.Stat(stale); err != nil {
+		t.Errorf("unfinished archive is removed in dry run: %v", err)
+	}
+
+	if _, err := os.Stat(job.Settings.SettingsCopyFilename()); !os.IsNotExist(err) {
+		t.Errorf("settings copy is saved in dry run: %v", err)
+	}
+}
+
+func TestSkipRunBefore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix commands are used")
+	}
+
+	job := newTestJob(t, func(js *JobSettings) {
+		js.RunBefore = []string{"false"}
+		js.RunBeforeFailure = "abort"
+	})
+
+	writeTestFiles(t, job.Path, map[string]string{"a.txt": "first file"})
+	job.Options.SkipRunBefore = true
+
+	result, err := job.Run()
+	if err != nil || result.Created == "" {
+		t.Errorf("archive should be created with skipped commands: %+v, %v", result, err)
+	}
+}
+
+func TestManifestWarning(t *testing.T) {
+	manifest_err := errors.New("disk full")
+
+	//manifest error alone
+	err := manifestWarning(nil, manifest_err)
+	if !IsWarning(err) || !errors.Is(err, manifest_err) {
+		t.Errorf("manifest error is not returned as warning: %v", err)
+	}
+
+	//archiver warning is kept
+	archiver_err := errors.New("file skipped")
+	err = manifestWarning(&Warning{Err: archiver_err}, manifest_err)
+	if !IsWarning(err) || !errors.Is(err, manifest_err) || !errors.Is(err, archiver_err) {
+		t.Errorf("archiver warning is lost: %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitoteam/mttools"
+)
+
+// Trash subdirectory of archives directory
+const TrashDirname = ".trash"
+
+// Suffix added to archive filename for sidecar file describing trashed archive
+const TrashInfoSuffix = ".trash.json"
+
+// JobTrashInfo describes why and when archive was moved to trash. It is stored next to archive
+// in trash directory.
+type JobTrashInfo struct {
+	Name    string    `json:"name,omitempty"`   //archive filename (file in trash gets ~N suffix if name is taken there)
+	Deleted time.Time `json:"deleted"`          //when archive was moved to trash
+	Reason  string    `json:"reason,omitempty"` //what deleted archive: cleanup, empty diff etc.
+}
+
+// JobTrashItem is single archive in trash directory.
+type JobTrashItem struct {
+	Name    string    //archive filename
+	Path    string    //full path in trash directory (filename differs from Name if same name was trashed before)
+	Size    int64     //archive file size
+	Deleted time.Time //when archive was moved to trash
+	Reason  string    //what deleted archive
+	Expires time.Time //when archive is purged automatically
+}
+
+// TrashPath returns trash directory path.
+func (js *JobSettings) TrashPath() string {
+	return filepath.Join(js.ArchivesPath, TrashDirname)
+}
+
+// deletes archive with its sidecar files or moves them to trash if 'trash_retention_days' is set
+func (job *Job) removeArchive(file *JobArchiveFile, reason string) error {
+	if job.Settings.TrashRetentionDays == 0 {
+		return file.Unlink()
+	}
+
+	return job.moveToTrash(file.Path, file.Path, reason)
+}
+
+// deletes (or moves to trash) full archive with all its diffs
+func (job *Job) removeFullItem(full_item *JobArchiveFullItem, reason string) error {
+	for _, diff_item := range full_item.DiffItemList {
+		if err := job.removeArchive(diff_item.File, reason); err != nil {
+			return err
+		}
+	}
+
+	return job.removeArchive(full_item.File, reason)
+}
+
+// deletes just created archive which is not needed (empty diff for example) or moves it to trash.
+// temp_path = archive file, archive_path = final name archive would have.
+func (job *Job) discardArchive(temp_path string, archive_path string, reason string) error {
+	if job.Settings.TrashRetentionDays == 0 {
+		if err := os.Remove(temp_path); err != nil {
+			return &DeleteError{Path: temp_path, Err: err}
+		}
+
+		return nil
+	}
+
+	return job.moveToTrash(temp_path, archive_path, reason)
+}
+
+// moves archive file (and sidecar files of archive_path) to trash directory and describes it there
+func (job *Job) moveToTrash(file_path string, archive_path string, reason string) error {
+	trash_path := job.Settings.TrashPath()
+
+	if err := os.MkdirAll(trash_path, 0777); err != nil {
+		return &DeleteError{Path: file_path, Err: err}
+	}
+
+	//same archive could be trashed before (restored from trash and deleted again for example)
+	name := filepath.Base(archive_path)
+	target := filepath.Join(trash_path, name)
+
+	for n := 2; trashNameTaken(target); n++ {
+		target = filepath.Join(trash_path, name+"~"+strconv.Itoa(n))
+	}
+
+	if err := os.Rename(file_path, target); err != nil {
+		return &DeleteError{Path: file_path, Err: err}
+	}
+
+	for _, sidecar := range []func(string) string{ManifestFilename, PinFilename} {
+		if mttools.IsFileExists(sidecar(archive_path)) {
+			if err := os.Rename(sidecar(archive_path), sidecar(target)); err != nil {
+				return &DeleteError{Path: sidecar(archive_path), Err: err}
+			}
+		}
+	}
+
+	info := &JobTrashInfo{
+		Name:    name,
+		Deleted: time.Now(),
+		Reason:  reason,
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(target+TrashInfoSuffix, data, 0666)
+}
+
+// checks if archive file or any of its sidecar files exists in trash
+func trashNameTaken(target string) bool {
+	for _, path := range []string{target, ManifestFilename(target), PinFilename(target), target + TrashInfoSuffix} {
+		if mttools.IsFileExists(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TrashList returns archives in trash directory sorted by deletion time (oldest first).
+func (job *Job) TrashList() ([]JobTrashItem, error) {
+	items := make([]JobTrashItem, 0)
+	trash_path := job.Settings.TrashPath()
+
+	if !mttools.IsDirExists(trash_path) {
+		return items, nil
+	}
+
+	list, err := os.ReadDir(trash_path)
+	if err != nil {
+		return nil, &ScanError{Path: trash_path, Err: err}
+	}
+
+	for _, entry := range list {
+		name := entry.Name()
+
+		//archives are listed by their description files
+		if entry.IsDir() || !strings.HasSuffix(name, TrashInfoSuffix) {
+			continue
+		}
+
+		item := JobTrashItem{
+			Path: filepath.Join(trash_path, strings.TrimSuffix(name, TrashInfoSuffix)),
+		}
+
+		file_info, err := os.Stat(item.Path)
+		if err != nil {
+			//description left without archive
+			continue
+		}
+
+		item.Size = file_info.Size()
+
+		data, err := os.ReadFile(item.Path + TrashInfoSuffix)
+		if err != nil {
+			return nil, &ScanError{Path: item.Path + TrashInfoSuffix, Err: err}
+		}
+
+		info := &JobTrashInfo{}
+		if err := json.Unmarshal(data, info); err != nil {
+			job.Log("Error reading trash info for %s: %s", filepath.Base(item.Path), err.Error())
+			info.Deleted = file_info.ModTime()
+		}
+
+		//archives trashed by older versions have no name in description
+		item.Name = info.Name
+		if item.Name == "" {
+			item.Name = filepath.Base(item.Path)
+		}
+
+		item.Deleted = info.Deleted
+		item.Reason = info.Reason
+		item.Expires = info.Deleted.AddDate(0, 0, job.Settings.TrashRetentionDays)
+
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deleted.Before(items[j].Deleted)
+	})
+
+	return items, nil
+}
+
+// TrashRestore moves archive with given filename (or its filename in trash) from trash back to archives
+// directory. If archive with this name was trashed several times newest one is restored first.
+func (job *Job) TrashRestore(name string) (JobTrashItem, error) {
+	items, err := job.TrashList()
+	if err != nil {
+		return JobTrashItem{}, err
+	}
+
+	//newest first
+	slices.Reverse(items)
+
+	for _, item := range items {
+		if item.Name != filepath.Base(name) && filepath.Base(item.Path) != filepath.Base(name) {
+			continue
+		}
+
+		target := job.archivePath(item.Name)
+		if mttools.IsFileExists(target) {
+			return item, fmt.Errorf("archive %s already exists in archives directory", item.Name)
+		}
+
+		if err := os.Rename(item.Path, target); err != nil {
+			return item, err
+		}
+
+		for _, sidecar := range []func(string) string{ManifestFilename, PinFilename} {
+			if mttools.IsFileExists(sidecar(item.Path)) {
+				if err := os.Rename(sidecar(item.Path), sidecar(target)); err != nil {
+					return item, err
+				}
+			}
+		}
+
+		if err := os.Remove(item.Path + TrashInfoSuffix); err != nil {
+			return item, &DeleteError{Path: item.Path + TrashInfoSuffix, Err: err}
+		}
+
+		job.Log("Archive restored from trash: %s", item.Name)
+
+		return item, nil
+	}
+
+	return JobTrashItem{}, errors.New("archive " + filepath.Base(name) + " not found in trash")
+}
+
+// TrashPurge deletes archives from trash permanently: all of them or expired ones only.
+// Nothing is deleted in dry run, archives to be deleted are listed only.
+func (job *Job) TrashPurge(expired_only bool) ([]string, error) {
+	purged := make([]string, 0)
+
+	items, err := job.TrashList()
+	if err != nil {
+		return purged, err
+	}
+
+	for _, item := range items {
+		if err := job.context().Err(); err != nil {
+			return purged, err
+		}
+
+		if expired_only && item.Expires.After(time.Now()) {
+			continue
+		}
+
+		if job.Options.DryRun {
+			job.Log("Dry run: would purge from trash %s", item.Name)
+		} else {
+			for _, path := range []string{item.Path, ManifestFilename(item.Path), PinFilename(item.Path), item.Path + TrashInfoSuffix} {
+				if !mttools.IsFileExists(path) {
+					continue
+				}
+
+				if err := os.Remove(path); err != nil {
+					return purged, &DeleteError{Path: path, Err: err}
+				}
+			}
+
+			job.Log("Purged from trash: %s", item.Name)
+		}
+
+		purged = append(purged, item.Name)
+	}
+
+	return purged, nil
+}
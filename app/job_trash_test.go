@@ -0,0 +1,129 @@
+package app
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) {
+		js.MaxFullCount = 1
+		js.TrashRetentionDays = 7
+	})
+
+	names := addTestArchives(t, job, []testArchiveSpec{
+		{kind: "F", days: 3, size: 100}, {kind: "D", days: 2, size: 10}, {kind: "F", days: 1, size: 100},
+	})
+
+	manifest := &JobArchiveManifest{Archive: names[0]}
+	if err := manifest.Save(job.archivePath(names[0])); err != nil {
+		t.Fatal(err)
+	}
+
+	//older full archive with its diff is moved to trash
+	if _, err := job.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := listTestArchives(t, job, job.Settings.ArchivesPath); !slices.Equal(got, names[2:]) {
+		t.Errorf("archives left: %v", got)
+	}
+
+	items, err := job.TrashList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trashed := make([]string, 0)
+	for _, item := range items {
+		trashed = append(trashed, item.Name)
+
+		if item.Reason == "" || item.Size == 0 || !item.Expires.After(item.Deleted) {
+			t.Errorf("trash item is not described: %+v", item)
+		}
+	}
+
+	slices.Sort(trashed)
+	if !slices.Equal(trashed, names[:2]) {
+		t.Fatalf("trash contains %v", trashed)
+	}
+
+	//restored with its manifest
+	if _, err := job.TrashRestore(names[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(ManifestFilename(job.archivePath(names[0]))); err != nil {
+		t.Errorf("manifest is not restored: %v", err)
+	}
+
+	if _, err := job.TrashRestore(names[0]); err == nil {
+		t.Errorf("no error restoring archive which is not in trash")
+	}
+
+	//nothing is expired yet
+	if purged, err := job.TrashPurge(true); err != nil || len(purged) != 0 {
+		t.Errorf("purged not expired archives: %v, %v", purged, err)
+	}
+
+	if purged, err := job.TrashPurge(false); err != nil || !slices.Equal(purged, names[1:2]) {
+		t.Errorf("purged %v, %v", purged, err)
+	}
+
+	if items, _ := job.TrashList(); len(items) != 0 {
+		t.Errorf("trash is not empty: %+v", items)
+	}
+}
+
+func TestTrashSameName(t *testing.T) {
+	job := newTestJob(t, func(js *JobSettings) {
+		js.TrashRetentionDays = 7
+	})
+
+	name := addTestArchive(t, job, job.Settings.DiffSuffix, time.Now().Add(-time.Hour), 100)
+	path := job.archivePath(name)
+
+	//same archive is trashed twice (restored from trash and deleted again for example)
+	for _, content := range []string{"first", "second"} {
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := job.removeArchive(&JobArchiveFile{Name: name, Path: path}, "test"); err != nil {
+			t.Fatal(err)
+		}
+
+		//deletion times should differ
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	items, err := job.TrashList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 || items[0].Name != name || items[1].Name != name || items[0].Path == items[1].Path {
+		t.Fatalf("trash items: %+v", items)
+	}
+
+	//newest one is restored first
+	for _, expected := range []string{"second", "first"} {
+		if _, err := job.TrashRestore(name); err != nil {
+			t.Fatal(err)
+		}
+
+		if data, _ := os.ReadFile(path); string(data) != expected {
+			t.Errorf("restored '%s', expected '%s'", data, expected)
+		}
+
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if items, _ := job.TrashList(); len(items) != 0 {
+		t.Errorf("trash is not empty: %+v", items)
+	}
+}
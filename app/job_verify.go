@@ -0,0 +1,155 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/mitoteam/mttools"
+)
+
+// JobVerifyResult is verification result for single archive.
+type JobVerifyResult struct {
+	File     *JobArchiveFile
+	Problems []string //errors found: archive can not be trusted
+	Warnings []string //something is not as expected, but archive is fine
+}
+
+// IsOk checks if no problems were found.
+func (r *JobVerifyResult) IsOk() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify checks archives integrity: manifests and checksums, archiver's integrity test and full+diff chains.
+// If latest_only is set only latest archive with all archives it is based on are checked.
+// Returns results for every checked archive.
+func (job *Job) Verify(latest_only bool) ([]JobVerifyResult, error) {
+	if err := job.ScanArchive(true); err != nil {
+		return nil, err
+	}
+
+	var files []*JobArchiveFile
+
+	if latest_only {
+		if last := job.Archive.LastFile(); last != nil {
+			if full_item := job.Archive.FindItem(last); full_item != nil {
+				files = full_item.Chain(last)
+			} else {
+				files = []*JobArchiveFile{last} //orphan
+			}
+		}
+	} else {
+		for index := range job.Archive.FilesList {
+			files = append(files, &job.Archive.FilesList[index])
+		}
+	}
+
+	results := make([]JobVerifyResult, 0, len(files))
+
+	for _, file := range files {
+		if err := job.context().Err(); err != nil {
+			return results, err
+		}
+
+		job.Log("Verifying %s", file.Name)
+
+		result := job.verifyFile(file)
+
+		for _, warning := range result.Warnings {
+			job.Log("WARNING %s: %s", file.Name, warning)
+		}
+
+		for _, problem := range result.Problems {
+			job.Log("FAILED %s: %s", file.Name, problem)
+		}
+
+		if result.IsOk() {
+			job.Log("OK %s", file.Name)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// verifies archive just created by run. Returns false if any problem found.
+func (job *Job) verifyCreatedArchive(archive_path string) bool {
+	job.Log("Verifying new archive before cleanup")
+
+	if err := job.ScanArchive(false); err != nil {
+		job.Log("FAILED %s: %s", filepath.Base(archive_path), err.Error())
+		return false
+	}
+
+	name := filepath.Base(archive_path)
+
+	for index := range job.Archive.FilesList {
+		file := &job.Archive.FilesList[index]
+
+		if file.Name != name {
+			continue
+		}
+
+		result := job.verifyFile(file)
+
+		for _, problem := range result.Problems {
+			job.Log("FAILED %s: %s", file.Name, problem)
+		}
+
+		return result.IsOk()
+	}
+
+	job.Log("FAILED %s: archive not found", name)
+
+	return false
+}
+
+func (job *Job) verifyFile(file *JobArchiveFile) JobVerifyResult {
+	result := JobVerifyResult{File: file}
+
+	//chain checks
+	if !file.IsFull {
+		if job.Archive.FindItem(file) == nil {
+			result.Problems = append(result.Problems, "orphan archive: no full archive found before it")
+		}
+
+		if file.Manifest != nil && file.Manifest.Base != "" {
+			if !mttools.IsFileExists(job.archivePath(file.Manifest.Base)) {
+				result.Problems = append(result.Problems, "base archive not found: "+file.Manifest.Base)
+			}
+		}
+	}
+
+	//manifest checks
+	if file.Manifest == nil {
+		result.Warnings = append(result.Warnings, "no manifest found, checksum can not be verified")
+	} else {
+		if file.Manifest.Size != file.Size {
+			result.Problems = append(result.Problems, fmt.Sprintf(
+				"file size is %d bytes, %d expected (truncated or modified)", file.Size, file.Manifest.Size,
+			))
+		}
+
+		if hash, err := mttools.FileSha256(file.Path); err != nil {
+			result.Problems = append(result.Problems, "can not calculate sha256: "+err.Error())
+		} else if hash != file.Manifest.Sha256 {
+			result.Problems = append(result.Problems, "sha256 checksum mismatch")
+		}
+	}
+
+	//archiver integrity test
+	archiver, err := job.archiverForFile(file.Path)
+	if err == nil {
+		err = archiver.Check()
+	}
+
+	if err == nil {
+		err = archiver.Test(file.Path)
+	}
+
+	if err != nil {
+		result.Problems = append(result.Problems, "integrity test failed: "+err.Error())
+	}
+
+	return result
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"os"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	job := newTestJob(t, testDateFormat)
+
+	for _, content := range []string{"first", "second version"} {
+		writeTestFiles(t, job.Path, map[string]string{"a.txt": content})
+
+		if _, err := job.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := job.Verify(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("two results expected, got %d", len(results))
+	}
+
+	for _, result := range results {
+		if !result.IsOk() || len(result.Warnings) > 0 {
+			t.Errorf("%s: %v %v", result.File.Name, result.Problems, result.Warnings)
+		}
+	}
+
+	full_path := job.Archive.FilesList[0].Path
+	diff_path := job.Archive.FilesList[1].Path
+
+	//damaged archive
+	f, err := os.OpenFile(diff_path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteString("garbage"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if results, _ := job.Verify(true); len(results) != 2 || !results[0].IsOk() || results[1].IsOk() {
+		t.Errorf("damaged archive is not detected: %+v", results)
+	}
+
+	//no manifest: warning only
+	if err := os.Remove(ManifestFilename(full_path)); err != nil {
+		t.Fatal(err)
+	}
+
+	if results, _ := job.Verify(true); !results[0].IsOk() || len(results[0].Warnings) != 1 {
+		t.Errorf("missing manifest: %+v", results[0])
+	}
+
+	//full archive is lost
+	if err := os.Remove(full_path); err != nil {
+		t.Fatal(err)
+	}
+
+	if results, _ := job.Verify(false); len(results) != 1 || results[0].IsOk() {
+		t.Errorf("orphan diff is not detected: %+v", results)
+	}
+}
+
+func TestVerifyCreatedArchive(t *testing.T) {
+	job := newTestJob(t, testDateFormat)
+	writeTestFiles(t, job.Path, map[string]string{"a.txt": "first file"})
+
+	archive_path, err := job.createArchive(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !job.verifyCreatedArchive(archive_path) {
+		t.Error("good archive is not verified")
+	}
+
+	if err := os.Truncate(archive_path, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if job.verifyCreatedArchive(archive_path) {
+		t.Error("truncated archive is verified")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package app
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processExists checks if process with given PID is running on this computer.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+
+	//process of another user still exists
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package app
+
+import (
+	"syscall"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	processStillActive             = 259
+)
+
+// processExists checks if process with given PID is running on this computer.
+func processExists(pid int) bool {
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		//process of another user still exists
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(handle)
+
+	var exit_code uint32
+	if err := syscall.GetExitCodeProcess(handle, &exit_code); err != nil {
+		return true
+	}
+
+	return exit_code == processStillActive
+}
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"mtsaver/app"
 
 	"github.com/spf13/cobra"
 )
@@ -14,22 +13,35 @@ func init() {
 		Long:  "Runs cleanup procedure for directory without creating new archive. If no path is given current directory is used.",
 
 		RunE: func(cmd *cobra.Command, args []string) error {
-			job, err := app.NewJobFromArgs(args)
+			job, err := newJob(args)
 			if err != nil {
 				return err
 			}
+			defer job.Close()
 
 			fmt.Println("Starting cleanup...")
 
-			if err = job.Cleanup(); err != nil {
+			result, err := job.Cleanup(cmd.Context())
+			if err != nil {
 				return err
 			}
 
-			fmt.Println("Done.")
+			if options.Job.DryRun {
+				fmt.Printf("Dry run. Archives to delete: %d\n", len(result.Deleted))
+			} else {
+				fmt.Printf("Done. Archives deleted: %d\n", len(result.Deleted))
+			}
 
 			return nil
 		},
 	}
 
+	cmd.Flags().BoolVar(
+		&options.Job.DryRun, "dry-run", false,
+		"Show archives that would be deleted without deleting them.",
+	)
+
+	addLockFlags(cmd)
+
 	rootCmd.AddCommand(cmd)
 }
@@ -1,8 +1,6 @@
 package cmd
 
 import (
-	"mtsaver/app"
-
 	"github.com/spf13/cobra"
 )
 
@@ -21,12 +19,15 @@ func init() {
 		},
 
 		RunE: func(cmd *cobra.Command, args []string) error {
-			job, err := app.NewJobFromArgs(args)
+			job, err := newJob(args)
 			if err != nil {
 				return err
 			}
+			defer job.Close()
 
-			job.Dump()
+			if err = job.Dump(cmd.Context()); err != nil {
+				return err
+			}
 
 			return nil
 		},
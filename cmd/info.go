@@ -21,24 +21,29 @@ func init() {
 			fmt.Println("Commit: " + app.Global.Commit)
 			fmt.Println("Built with: " + app.Global.BuiltWith)
 			fmt.Println()
-			fmt.Println("7-zip command: " + app.Global.SevenZipCmd)
-			fmt.Println("7-zip info: " + app.Global.SevenZipInfo)
+			if options.SevenZip.Cmd == "" {
+				fmt.Println("7-zip command: not found")
+			} else {
+				fmt.Println("7-zip command: " + options.SevenZip.Cmd)
+			}
+			fmt.Println("7-zip info: " + options.SevenZip.Info)
 			fmt.Println()
 
-			job, err := app.NewJobFromArgs(args)
+			job, err := newJob(args)
 			if err != nil {
 				return err
 			}
+			defer job.Close()
 
 			settings_filename := job.SettingsFilename()
 
 			fmt.Println(" --- Directory Info ---")
-			fmt.Println("Path:", job.Path)
+			fmt.Println("Path:", job.Path())
 
 			if mttools.IsFileExists(settings_filename) {
 				fmt.Println("Settings file:", settings_filename)
 				fmt.Println("\n --- Directory Settings ---")
-				job.Settings.Print()
+				job.Settings().Print()
 			} else {
 				fmt.Printf("no settings file found (%s)\n", filepath.Base(settings_filename))
 			}
@@ -3,7 +3,6 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"mtsaver/app"
 
 	"github.com/mitoteam/mttools"
 
@@ -16,20 +15,21 @@ func init() {
 		Short: "Creates settings file with defaults. If no path is given current directory used. --settings option can be used to specify settings file name or location explicitly.",
 
 		RunE: func(cmd *cobra.Command, args []string) error {
-			job, err := app.NewJobFromArgs(args)
+			job, err := newJob(args)
 			if err != nil {
 				return err
 			}
+			defer job.Close()
 
-			if app.JobRuntimeOptions.DefaultsFrom != "" {
-				if err := job.Settings.LoadFromFile(app.JobRuntimeOptions.DefaultsFrom); err != nil {
+			if options.DefaultsFrom != "" {
+				if err := job.Settings().LoadFromFile(options.DefaultsFrom); err != nil {
 					return err
 				}
 			}
 
 			filename := job.SettingsFilename()
 
-			if !app.JobRuntimeOptions.Print && mttools.IsFileExists(filename) {
+			if !options.Print && mttools.IsFileExists(filename) {
 				return errors.New("can not initialize existing file: " + filename)
 			}
 
@@ -39,10 +39,10 @@ options listed here with its default values. Recommendation is to edit options y
 want to change and remove all others to keep this as simple as possible.
 `
 
-			if app.JobRuntimeOptions.Print {
-				job.Settings.Print()
+			if options.Print {
+				job.Settings().Print()
 			} else {
-				if err := job.Settings.SaveToFile(filename, comment); err != nil {
+				if err := job.Settings().SaveToFile(filename, comment); err != nil {
 					return err
 				}
 
@@ -54,12 +54,12 @@ want to change and remove all others to keep this as simple as possible.
 	}
 
 	cmd.Flags().StringVar(
-		&app.JobRuntimeOptions.DefaultsFrom, "defaults-from", "",
+		&options.DefaultsFrom, "defaults-from", "",
 		"settings file used to read defaults from before generating new setting with full options set",
 	)
 
 	cmd.Flags().BoolVar(
-		&app.JobRuntimeOptions.Print, "print", false,
+		&options.Print, "print", false,
 		"print default settings instead of writing to file",
 	)
 
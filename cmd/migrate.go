@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "migrate [/path/to/directory]",
+		Short: "Renames existing archives after archive naming settings were changed",
+		Long:  "Renames archives created with previous naming settings (archive_name, archive_name_template, suffixes, date_format, timestamp_zone) so they match current ones. Previous settings are loaded from file given with --from-settings or are current ones with --from-zone value applied. If no path is given current directory is used.",
+
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := CallParentPreRun(cmd, args); err != nil {
+				return err
+			}
+
+			if options.MigrateFromSettings == "" && options.MigrateFromZone == "" {
+				return errors.New("previous naming settings are required: use --from-settings or --from-zone option")
+			}
+
+			if options.MigrateFromZone != "" && options.MigrateFromZone != "local" && options.MigrateFromZone != "utc" {
+				return errors.New("valid values for --from-zone option are 'local', 'utc'")
+			}
+
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, err := newJob(args)
+			if err != nil {
+				return err
+			}
+			defer job.Close()
+
+			//previous settings: from file or current ones with changed values
+			from := *job.Settings()
+
+			if options.MigrateFromSettings != "" {
+				if from, err = job.LoadPreviousSettings(options.MigrateFromSettings); err != nil {
+					return err
+				}
+			}
+
+			if options.MigrateFromZone != "" {
+				from.TimestampZone = options.MigrateFromZone
+			}
+
+			items, err := job.Migrate(cmd.Context(), from, options.MigrateDryRun)
+			if err != nil {
+				return err
+			}
+
+			if options.MigrateDryRun {
+				for _, item := range items {
+					fmt.Printf("%s -> %s\n", item.From, item.To)
+				}
+
+				fmt.Printf("Archives to rename: %d (dry run, nothing renamed)\n", len(items))
+			} else {
+				fmt.Printf("Done. Archives renamed: %d\n", len(items))
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(
+		&options.MigrateFromSettings, "from-settings", "",
+		"Settings file archives were created with (copy of .mtsaver.yml before naming settings were changed).",
+	)
+
+	cmd.Flags().StringVar(
+		&options.MigrateFromZone, "from-zone", "",
+		"Previous 'timestamp_zone' setting value archives were created with: local|utc (overrides value from --from-settings file).",
+	)
+
+	cmd.Flags().BoolVar(
+		&options.MigrateDryRun, "dry-run", false,
+		"Only show what archives would be renamed.",
+	)
+
+	addLockFlags(cmd)
+
+	rootCmd.AddCommand(cmd)
+}
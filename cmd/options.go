@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"mtsaver/pkg/saver"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Command line options (bound to flags)
+var options struct {
+	SevenZipCmd string         // global: --7zip
+	SevenZip    saver.SevenZip // 7-Zip detected by --7zip option
+	NoConsole   bool           // global: --no-console
+
+	Job saver.Options // job runtime options: global --settings, run --force-full, --password, --wait etc.
+
+	DefaultsFrom string // init --defaults-from <string>
+	Print        bool   // init --print
+
+	RestoreTo      string   // restore --to
+	RestoreLatest  bool     // restore --latest
+	RestoreAt      string   // restore --at
+	RestoreBefore  string   // restore --before
+	RestoreAfter   string   // restore --after
+	RestoreArchive string   // restore --archive
+	RestorePaths   []string // restore --path (several times)
+
+	RestoreFromArchives string // restore --from-archives
+
+	VerifyLatest bool // verify --latest
+
+	MigrateFromSettings string // migrate --from-settings
+	MigrateFromZone     string // migrate --from-zone
+	MigrateDryRun       bool   // migrate --dry-run
+
+	PinReason string // pin --reason
+
+	TrashExpired bool // trash purge --expired
+}
+
+func init() {
+	//default values
+	options.Job.SettingsFilename = saver.DefaultSettingsFilename
+}
+
+// creates job for directory given as first argument (current directory if no arguments given)
+func newJob(args []string) (*saver.Job, error) {
+	path := "." //current directory
+
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	return saver.NewJob(path, jobOptions())
+}
+
+// job options with detected 7-Zip command
+func jobOptions() saver.Options {
+	job_options := options.Job
+	job_options.SevenZipCmd = options.SevenZip.Cmd
+
+	return job_options
+}
+
+// adds --wait and --break-lock flags to command taking archives directory lock
+func addLockFlags(cmd *cobra.Command) {
+	cmd.Flags().Var(
+		lockWaitValue{&options.Job.LockWait}, "wait",
+		"Wait for archives directory lock held by another process: --wait forever = until it is released, --wait 30m = 30 minutes at most. Default: fail right away.",
+	)
+
+	cmd.Flags().BoolVar(
+		&options.Job.BreakLock, "break-lock", false,
+		"Remove archives directory lock held by another process. Use it only if you are sure that process is not running.",
+	)
+}
+
+// --wait flag value: duration or "forever" (stored as negative duration)
+type lockWaitValue struct {
+	wait *time.Duration
+}
+
+func (v lockWaitValue) String() string {
+	if *v.wait < 0 {
+		return lockWaitForever
+	}
+
+	if *v.wait == 0 {
+		return "0"
+	}
+
+	return v.wait.String()
+}
+
+func (v lockWaitValue) Set(value string) error {
+	if value == lockWaitForever {
+		*v.wait = -1
+		return nil
+	}
+
+	wait, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	if wait < 0 {
+		return fmt.Errorf("negative duration %s, use '%s' to wait until lock is released", value, lockWaitForever)
+	}
+
+	*v.wait = wait
+
+	return nil
+}
+
+func (v lockWaitValue) Type() string {
+	return "duration"
+}
+
+const lockWaitForever = "forever"
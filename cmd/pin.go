@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "pin <archive> [/path/to/directory]",
+		Short: "Pins archive so cleanup never deletes it",
+		Long:  "Pins archive with given filename: cleanup never deletes it together with all archives needed to restore it (full archive and diffs it is based on). Use 'unpin' to remove hold. If no path is given current directory is used.",
+		Args:  cobra.RangeArgs(1, 2),
+
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := CallParentPreRun(cmd, args); err != nil {
+				return err
+			}
+
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, err := newJob(args[1:])
+			if err != nil {
+				return err
+			}
+			defer job.Close()
+
+			if _, err = job.Pin(cmd.Context(), args[0], options.PinReason); err != nil {
+				return err
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(
+		&options.PinReason, "reason", "",
+		"Why archive is pinned (shown by 'dump' command).",
+	)
+
+	rootCmd.AddCommand(cmd)
+}
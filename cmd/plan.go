@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mitoteam/mttools"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "plan [/path/to/directory]",
+		Short: "Shows what next backup run would do and why",
+		Long:  "Shows what next backup run would do: which archive would be created (full or diff) with all rules checked, which archives would be deleted by cleanup and estimated archive size. Nothing is changed. If no path is given current directory is used.",
+
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := CallParentPreRun(cmd, args); err != nil {
+				return err
+			}
+
+			if mttools.CountValues(true, options.Job.ForceFull, options.Job.ForceDiff) > 1 {
+				return errors.New("can not force both full and differential backups simultaneously")
+			}
+
+			//planning changes nothing on disk: archives directory is not created, log file is not written
+			options.Job.DryRun = true
+
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, err := newJob(args)
+			if err != nil {
+				return err
+			}
+			defer job.Close()
+
+			plan, err := job.Plan(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			settings := job.Settings()
+
+			if plan.Full {
+				fmt.Printf("Next archive: %s\n", plan.Kind(settings))
+			} else {
+				fmt.Printf("Next archive: %s for %s\n", plan.Kind(settings), plan.Base.File.Name)
+			}
+
+			fmt.Printf("Reason: %s\n", plan.Reason)
+
+			if len(plan.Rules) > 0 {
+				fmt.Println("\nRules checked:")
+
+				for _, rule := range plan.Rules {
+					mark := " "
+					if rule.Triggered {
+						mark = "x"
+					}
+
+					fmt.Printf("  [%s] %s: %s (limit: %s)\n", mark, rule.Setting, rule.Value, rule.Threshold)
+				}
+			}
+
+			fmt.Printf("\nCleanup (%s run): ", settings.Cleanup)
+			if len(plan.Cleanup) == 0 {
+				fmt.Println("nothing to delete")
+			} else {
+				fmt.Printf("%d archives to delete\n", len(plan.Cleanup))
+
+				for _, name := range plan.Cleanup {
+					fmt.Println("  " + name)
+				}
+			}
+
+			fmt.Printf("\nFiles to pack: %d, size: %s\n", plan.Files, mttools.FormatFileSize(plan.SourceSize))
+
+			if plan.Ratio > 0 {
+				fmt.Printf(
+					"Estimated archive size: %s (compression ratio of latest full archive: %.0f%%)\n",
+					mttools.FormatFileSize(plan.EstimatedSize), plan.Ratio*100,
+				)
+			} else {
+				fmt.Printf("Estimated archive size: up to %s (compression ratio is unknown)\n", mttools.FormatFileSize(plan.EstimatedSize))
+			}
+
+			if plan.NoSpace != "" {
+				fmt.Printf("WARNING: run would fail, not enough space for new archive: %s\n", plan.NoSpace)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(
+		&options.Job.ForceFull, "force-full", false,
+		"Plan as if 'run --force-full' is used.",
+	)
+
+	cmd.Flags().BoolVar(
+		&options.Job.ForceDiff, "force-diff", false,
+		"Plan as if 'run --force-diff' is used.",
+	)
+
+	rootCmd.AddCommand(cmd)
+}
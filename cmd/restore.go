@@ -3,8 +3,8 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"mtsaver/app"
-	"os"
+	"mtsaver/pkg/saver"
+	"time"
 
 	"github.com/mitoteam/mttools"
 	"github.com/spf13/cobra"
@@ -14,7 +14,7 @@ func init() {
 	cmd := &cobra.Command{
 		Use:   "restore [/path/to/directory]",
 		Short: "Unpacks FULL+DIFF archives to specified directory.",
-		Long:  "Restores FULL+DIFF archives to specified directory. --to option is required. Directory should not exist or should be empty. If no --latest option provided programs asks interactively for the which archive to restore.",
+		Long:  "Restores FULL+DIFF archives to specified directory. --to option is required. Directory should not exist or should be empty. Archive to restore is chosen by --latest, --at, --before, --after or --archive option. If none of them is provided program asks interactively for the which archive to restore. Use --path to restore single files or subdirectories only. Use --from-archives to restore without source directory.",
 
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			if err := CallParentPreRun(cmd, args); err != nil {
@@ -25,65 +25,54 @@ func init() {
 		},
 
 		RunE: func(cmd *cobra.Command, args []string) error {
-			job, err := app.NewJobFromArgs(args)
-			if err != nil {
-				return err
-			}
-
-			//do not run if directory has no .mtsaver.yaml and no --settings option specified
-			if !job.Settings.LoadedFromFile {
-				return fmt.Errorf("Directory %s does not contain %s file", job.Path, app.DefaultSettingsFilename)
-			}
-
-			// check path provided
-			if app.JobRuntimeOptions.RestoreTo == "" {
-				return fmt.Errorf("--to option is required")
-			}
+			var job *saver.Job
+			var err error
 
-			if app.JobRuntimeOptions.RestoreTo, err = mttools.GetDirAbsolutePath(app.JobRuntimeOptions.RestoreTo); err != nil {
-				//ignore "directory does not exists" error
-				if err.Error() != fmt.Sprintf("\"%s\" directory does not exists", app.JobRuntimeOptions.RestoreTo) {
-					return err
+			if options.RestoreFromArchives != "" {
+				if len(args) > 0 {
+					return errors.New("directory argument can not be used with --from-archives option")
 				}
-			}
 
-			job.Log("[%s v%s] Starting directory restore: %s", app.Global.AppName, app.Global.Version, job.Path)
-
-			if mttools.IsDirExists(app.JobRuntimeOptions.RestoreTo) {
-				empty, err := mttools.IsDirEmpty(app.JobRuntimeOptions.RestoreTo)
-
-				if err != nil {
+				if job, err = saver.NewJobFromArchives(options.RestoreFromArchives, jobOptions()); err != nil {
 					return err
 				}
-
-				if !empty {
-					return fmt.Errorf("Directory %s is not empty", app.JobRuntimeOptions.RestoreTo)
-				}
 			} else {
-				if err := os.MkdirAll(app.JobRuntimeOptions.RestoreTo, 0777); err != nil {
+				if job, err = newJob(args); err != nil {
 					return err
 				}
 
-				job.Log("Destination directory created: %s", app.JobRuntimeOptions.RestoreTo)
+				//do not run if directory has no .mtsaver.yaml and no --settings option specified
+				if !job.Settings().LoadedFromFile {
+					job.Close()
+					return fmt.Errorf("Directory %s does not contain %s file", job.Path(), saver.DefaultSettingsFilename)
+				}
 			}
+			defer job.Close()
 
-			//get all available archives
-			job.ScanArchive(app.JobRuntimeOptions.RestoreLatest)
+			// check path provided
+			if options.RestoreTo == "" {
+				return fmt.Errorf("--to option is required")
+			}
 
-			var ja *app.JobArchiveFile
+			selector, err := restoreSelector(job)
+			if err != nil {
+				return err
+			}
 
-			if app.JobRuntimeOptions.RestoreLatest {
-				ja = job.Archive.LastFile()
-			} else {
-				ja = nil
+			if selector.IsEmpty() {
+				//get all available archives
+				archive, err := job.Scan(cmd.Context())
+				if err != nil {
+					return err
+				}
 
 				//prepare options
-				options := make([]string, 0, len(job.Archive.FilesList))
-				for _, f := range job.Archive.FilesList {
-					options = append(options, f.Name)
+				choices := make([]string, 0, len(archive.FilesList))
+				for _, f := range archive.FilesList {
+					choices = append(choices, f.Name)
 				}
 
-				choice, err := mttools.AskUserChoiceSingle("Choose archive to restore: ", options)
+				choice, err := mttools.AskUserChoiceSingle("Choose archive to restore: ", choices)
 
 				if err != nil {
 					return err
@@ -93,10 +82,10 @@ func init() {
 					return errors.New("No archive selected")
 				}
 
-				ja = &job.Archive.FilesList[choice]
+				selector.Name = archive.FilesList[choice].Name
 			}
 
-			if err = job.Restore(app.JobRuntimeOptions.RestoreTo, ja); err != nil {
+			if _, err = job.Restore(cmd.Context(), options.RestoreTo, selector, options.RestorePaths); err != nil {
 				return err
 			}
 
@@ -105,16 +94,85 @@ func init() {
 	}
 
 	cmd.Flags().BoolVar(
-		&app.JobRuntimeOptions.RestoreLatest, "latest", false,
+		&options.RestoreLatest, "latest", false,
 		"Restore latest available FULL+DIFF pair without asking.",
 	)
 
 	cmd.Flags().StringVar(
-		&app.JobRuntimeOptions.RestoreTo, "to", "",
+		&options.RestoreAt, "at", "",
+		"Restore newest archive created at or before given time (\"YYYY-MM-DD hh:mm:ss\", seconds or time can be omitted).",
+	)
+
+	cmd.Flags().StringVar(
+		&options.RestoreBefore, "before", "",
+		"Restore newest archive created before given time.",
+	)
+
+	cmd.Flags().StringVar(
+		&options.RestoreAfter, "after", "",
+		"Restore oldest archive created after given time.",
+	)
+
+	cmd.Flags().StringVar(
+		&options.RestoreArchive, "archive", "",
+		"Restore archive with given filename.",
+	)
+
+	cmd.MarkFlagsMutuallyExclusive("latest", "at", "before", "after", "archive")
+
+	cmd.Flags().StringVar(
+		&options.RestoreTo, "to", "",
 		"[REQUIRED] Path to directory to unpack archives. Directory should not exist or should be empty.",
 	)
 
+	cmd.Flags().StringArrayVar(
+		&options.RestorePaths, "path", nil,
+		"Restore only files matching path (relative to directory). Glob patterns and directories are supported. Can be used several times.",
+	)
+
+	cmd.Flags().StringVar(
+		&options.RestoreFromArchives, "from-archives", "",
+		"Restore using settings copy saved in archives directory by 'run' command. Source directory and its settings file are not required.",
+	)
+
+	cmd.Flags().StringVar(
+		&options.Job.Host, "host", "",
+		"Restore archives created on computer with given name (when 'archive_name_template' has {host} placeholder). Default: this computer name.",
+	)
+
+	cmd.Flags().StringVar(
+		&options.Job.Password, "password", "",
+		"Password for .7z archives (overrides 'password' in settings). Settings copy in archives directory has no password saved.",
+	)
+
 	cmd.MarkFlagRequired("to")
 
+	addLockFlags(cmd)
+
 	rootCmd.AddCommand(cmd)
 }
+
+// builds archive selector from restore command options
+func restoreSelector(job *saver.Job) (selector saver.ArchiveSelector, err error) {
+	selector.Latest = options.RestoreLatest
+	selector.Name = options.RestoreArchive
+
+	for _, option := range []struct {
+		value  string
+		target *time.Time
+	}{
+		{options.RestoreAt, &selector.At},
+		{options.RestoreBefore, &selector.Before},
+		{options.RestoreAfter, &selector.After},
+	} {
+		if option.value == "" {
+			continue
+		}
+
+		if *option.target, err = job.ParseTime(option.value); err != nil {
+			return selector, err
+		}
+	}
+
+	return selector, nil
+}
@@ -3,8 +3,11 @@
 package cmd
 
 import (
+	"fmt"
 	"mtsaver/app"
+	"mtsaver/pkg/saver"
 
+	"github.com/mitoteam/mttools"
 	"github.com/spf13/cobra"
 )
 
@@ -26,26 +29,26 @@ Based on 7-Zip archiver https://www.7-zip.org`,
 		cmd.Help()
 	},
 
-	PersistentPreRunE: app.SetupBeforeCommand,
+	PersistentPreRunE: setupBeforeCommand,
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(
-		&app.Global.SevenZipCmd,
+		&options.SevenZipCmd,
 		"7zip",
 		"auto",
 		"Command to run 7-Zip executable. \"auto\" = try to auto-detect",
 	)
 
 	rootCmd.PersistentFlags().StringVar(
-		&app.JobRuntimeOptions.SettingsFilename,
+		&options.Job.SettingsFilename,
 		"settings",
 		app.DefaultSettingsFilename,
 		"Filename or path to directory settings file. Used by 'run', 'info', 'init' commands. If filename only given it is looked for in directory itself.",
 	)
 
 	rootCmd.PersistentFlags().BoolVar(
-		&app.JobRuntimeOptions.NoConsole, "no-console", false,
+		&options.NoConsole, "no-console", false,
 		"Windows only: hides console window right after app start.",
 	)
 }
@@ -54,6 +57,20 @@ func Root() *cobra.Command {
 	return rootCmd
 }
 
+func setupBeforeCommand(cmd *cobra.Command, args []string) (err error) {
+	if options.NoConsole {
+		if mttools.IsWindows() {
+			mttools.HideConsole()
+		} else {
+			fmt.Println("--no-console option ignored under Linux")
+		}
+	}
+
+	options.SevenZip, err = saver.DetectSevenZip(options.SevenZipCmd)
+
+	return err
+}
+
 // CallParentPreRun helper function calls parent command's PersistentPreRun
 // or PersistentPreRunE hooks if they are defined.
 func CallParentPreRun(cmd *cobra.Command, args []string) error {
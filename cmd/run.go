@@ -3,7 +3,7 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"mtsaver/app"
+	"mtsaver/pkg/saver"
 
 	"github.com/mitoteam/mttools"
 
@@ -22,16 +22,16 @@ func init() {
 			}
 
 			//Options checks
-			if mttools.CountValues(true, app.JobRuntimeOptions.ForceFull, app.JobRuntimeOptions.ForceDiff) > 1 {
+			if mttools.CountValues(true, options.Job.ForceFull, options.Job.ForceDiff) > 1 {
 				return errors.New("can not force both full and differential backups simultaneously")
 			}
 
 			//Options messages
-			if app.JobRuntimeOptions.ForceFull {
+			if options.Job.ForceFull {
 				fmt.Println("Full backup forced.")
 			}
 
-			if app.JobRuntimeOptions.ForceDiff {
+			if options.Job.ForceDiff {
 				fmt.Println("Differential backup forced.")
 			}
 
@@ -39,17 +39,18 @@ func init() {
 		},
 
 		RunE: func(cmd *cobra.Command, args []string) error {
-			job, err := app.NewJobFromArgs(args)
+			job, err := newJob(args)
 			if err != nil {
 				return err
 			}
+			defer job.Close()
 
 			//do not run if directory has no .mtsaver.yaml and no --settings option specified
-			if !job.Settings.LoadedFromFile {
-				return fmt.Errorf("Directory %s does not contain %s file", job.Path, app.DefaultSettingsFilename)
+			if !job.Settings().LoadedFromFile {
+				return fmt.Errorf("Directory %s does not contain %s file", job.Path(), saver.DefaultSettingsFilename)
 			}
 
-			if err = job.Run(); err != nil {
+			if _, err = job.Run(cmd.Context()); err != nil {
 				return err
 			}
 
@@ -58,34 +59,56 @@ func init() {
 	}
 
 	cmd.Flags().BoolVar(
-		&app.JobRuntimeOptions.ForceFull, "force-full", false,
+		&options.Job.ForceFull, "force-full", false,
 		"Create full archive even if conditions in settings require differential one.",
 	)
 
 	cmd.Flags().BoolVar(
-		&app.JobRuntimeOptions.ForceDiff, "force-diff", false,
-		"Create differential archive even if conditions in settings require full one. This option can not be used if there are no full archives created yet.",
+		&options.Job.ForceDiff, "force-diff", false,
+		"Create differential (or incremental with 'mode: incremental' setting) archive even if conditions in settings require full one. This option can not be used if there are no full archives created yet.",
 	)
 
 	cmd.Flags().BoolVar(
-		&app.JobRuntimeOptions.Solid, "solid", false,
+		&options.Job.Solid, "solid", false,
 		"Create solid archives.",
 	)
 
 	cmd.Flags().StringVar(
-		&app.JobRuntimeOptions.Password, "password", "",
+		&options.Job.Password, "password", "",
 		"Set .7z archive password (or override 'password' in settings).",
 	)
 
 	cmd.Flags().BoolVar(
-		&app.JobRuntimeOptions.EncryptFilenames, "encrypt-filenames", false,
+		&options.Job.EncryptFilenames, "encrypt-filenames", false,
 		"Encrypt filenames in archive (used only when password is set).",
 	)
 
+	cmd.Flags().StringVar(
+		&options.Job.Label, "label", "",
+		"Label to put in archive name ({label} placeholder in 'archive_name_template' setting).",
+	)
+
 	cmd.Flags().BoolVar(
-		&app.JobRuntimeOptions.NoLog, "no-log", false,
+		&options.Job.Pin, "pin", false,
+		"Pin created archive: cleanup never deletes it (--label value is saved as pin reason).",
+	)
+
+	cmd.Flags().BoolVar(
+		&options.Job.NoLog, "no-log", false,
 		"Do not create log file in archives directory (log_format: disable).",
 	)
 
+	cmd.Flags().BoolVar(
+		&options.Job.DryRun, "dry-run", false,
+		"Show what would be done (archive name, 7-Zip command lines, archives to delete) without changing anything on disk.",
+	)
+
+	cmd.Flags().BoolVar(
+		&options.Job.SkipRunBefore, "skip-run-before", false,
+		"Do not run commands from 'run_before' setting (useful with --dry-run).",
+	)
+
+	addLockFlags(cmd)
+
 	rootCmd.AddCommand(cmd)
 }
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/mitoteam/mttools"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "trash",
+		Short: "Manages archives moved to trash by cleanup",
+		Long:  "Manages archives moved to .trash subdirectory of archives directory by cleanup ('trash_retention_days' setting): lists them, restores back or purges permanently.",
+
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			//subcommands get here: run root command hooks
+			return CallParentPreRun(cmd.Parent(), args)
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list [/path/to/directory]",
+		Short: "Lists archives in trash",
+		Long:  "Lists archives in trash with deletion time, reason and time they are purged at. If no path is given current directory is used.",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, err := newJob(args)
+			if err != nil {
+				return err
+			}
+			defer job.Close()
+
+			items, err := job.TrashList()
+			if err != nil {
+				return err
+			}
+
+			if len(items) == 0 {
+				fmt.Println("Trash is empty")
+				return nil
+			}
+
+			for _, item := range items {
+				expires := "purged after " + item.Expires.Format("2006-01-02 15:04")
+				if job.Settings().TrashRetentionDays == 0 {
+					expires = "not purged automatically"
+				} else if item.Expires.Before(time.Now()) {
+					expires = "expired, purged by next cleanup"
+				}
+
+				name := item.Name
+				if trash_name := filepath.Base(item.Path); trash_name != item.Name {
+					name += " (in trash as " + trash_name + ")"
+				}
+
+				fmt.Printf(
+					"%s, size: %s, deleted: %s (%s), %s\n", name, mttools.FormatFileSize(item.Size),
+					item.Deleted.Format("2006-01-02 15:04"), item.Reason, expires,
+				)
+			}
+
+			return nil
+		},
+	}
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore <archive> [/path/to/directory]",
+		Short: "Moves archive from trash back to archives directory",
+		Long:  "Moves archive with given filename (with its manifest and pin) from trash back to archives directory. If archive with same name was trashed several times newest one is restored first. Cleanup can delete it again if retention settings do not keep it. If no path is given current directory is used.",
+		Args:  cobra.RangeArgs(1, 2),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, err := newJob(args[1:])
+			if err != nil {
+				return err
+			}
+			defer job.Close()
+
+			if _, err = job.TrashRestore(cmd.Context(), args[0]); err != nil {
+				return err
+			}
+
+			return nil
+		},
+	}
+
+	purgeCmd := &cobra.Command{
+		Use:   "purge [/path/to/directory]",
+		Short: "Deletes archives from trash permanently",
+		Long:  "Deletes all archives from trash permanently (or expired ones only with --expired option). If no path is given current directory is used.",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, err := newJob(args)
+			if err != nil {
+				return err
+			}
+			defer job.Close()
+
+			purged, err := job.TrashPurge(cmd.Context(), options.TrashExpired)
+			if err != nil {
+				return err
+			}
+
+			if options.Job.DryRun {
+				fmt.Printf("Dry run. Archives to purge: %d\n", len(purged))
+			} else {
+				fmt.Printf("Done. Archives purged: %d\n", len(purged))
+			}
+
+			return nil
+		},
+	}
+
+	purgeCmd.Flags().BoolVar(
+		&options.TrashExpired, "expired", false,
+		"Purge only archives kept in trash longer than 'trash_retention_days'.",
+	)
+
+	purgeCmd.Flags().BoolVar(
+		&options.Job.DryRun, "dry-run", false,
+		"Show archives that would be purged without deleting them.",
+	)
+
+	cmd.AddCommand(listCmd, restoreCmd, purgeCmd)
+
+	rootCmd.AddCommand(cmd)
+}
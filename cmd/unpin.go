@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "unpin <archive> [/path/to/directory]",
+		Short: "Removes hold from pinned archive",
+		Long:  "Removes hold from archive pinned by 'pin' command or 'run --pin'. Archive is deleted by next cleanup if retention settings do not keep it. If no path is given current directory is used.",
+		Args:  cobra.RangeArgs(1, 2),
+
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := CallParentPreRun(cmd, args); err != nil {
+				return err
+			}
+
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, err := newJob(args[1:])
+			if err != nil {
+				return err
+			}
+			defer job.Close()
+
+			if _, err = job.Unpin(cmd.Context(), args[0]); err != nil {
+				return err
+			}
+
+			return nil
+		},
+	}
+
+	rootCmd.AddCommand(cmd)
+}
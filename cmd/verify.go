@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "verify [/path/to/directory]",
+		Short: "Checks integrity of all archives created for directory",
+		Long:  "Checks integrity of all archives created for directory: archiver's integrity test, size and sha256 from manifest files, full+diff chains. Exits with non-zero code if any problem found. If no path is given current directory is used.",
+
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := CallParentPreRun(cmd, args); err != nil {
+				return err
+			}
+
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, err := newJob(args)
+			if err != nil {
+				return err
+			}
+			defer job.Close()
+
+			results, err := job.Verify(cmd.Context(), options.VerifyLatest)
+			if err != nil {
+				return err
+			}
+
+			failed := 0
+			for _, result := range results {
+				if !result.IsOk() {
+					failed++
+				}
+			}
+
+			fmt.Printf("Archives checked: %d, failed: %d\n", len(results), failed)
+
+			if failed > 0 {
+				return fmt.Errorf("%d of %d archives failed verification", failed, len(results))
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(
+		&options.VerifyLatest, "latest", false,
+		"Verify latest archive only (with all archives it is based on).",
+	)
+
+	cmd.Flags().StringVar(
+		&options.Job.Password, "password", "",
+		"Password for .7z archives (overrides 'password' in settings).",
+	)
+
+	rootCmd.AddCommand(cmd)
+}
@@ -6,6 +6,7 @@ go 1.24
 //replace github.com/mitoteam/mttools => ../mttools
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/mitoteam/mttools v1.0.8
 	github.com/spf13/cobra v1.10.2
 )
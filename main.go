@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"log"
 	"mtsaver/app"
 	"mtsaver/cmd"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 //go:embed LICENSE.md
@@ -13,8 +17,13 @@ var licenseString string
 func main() {
 	app.Global.License = licenseString
 
+	//running archiver is stopped and unfinished archive is removed on Ctrl-C or termination
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	//cli application - we just let cobra to do it job
-	if err := cmd.Root().Execute(); err != nil {
-		log.Fatalln(err)
+	if err := cmd.Root().ExecuteContext(ctx); err != nil {
+		log.Println(err)
+		os.Exit(app.ExitCode(err))
 	}
 }
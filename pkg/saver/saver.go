@@ -0,0 +1,300 @@
+// Package saver is mtsaver API for Go programs. It allows to create, clean up, verify and restore
+// directory backups in-process. There is no package level state: every Job has its own options,
+// so several jobs with different settings can be used in one program.
+package saver
+
+import (
+	"context"
+	"time"
+
+	"mtsaver/app"
+)
+
+// Default settings filename looked for in job directory
+const DefaultSettingsFilename = app.DefaultSettingsFilename
+
+// Options are job runtime options. They override values from settings file.
+type Options = app.JobOptions
+
+// Settings are directory settings (loaded from settings file).
+type Settings = app.JobSettings
+
+// Archive is scanned archives directory: all archives list and FULL -> DIFF[] tree.
+type Archive = app.JobArchive
+
+// ArchiveFile is single archive file.
+type ArchiveFile = app.JobArchiveFile
+
+// ArchiveSelector describes which archive to restore.
+type ArchiveSelector = app.ArchiveSelector
+
+// RunResult describes what was done by Job.Run.
+type RunResult = app.JobRunResult
+
+// CleanupResult describes what was done by Job.Cleanup.
+type CleanupResult = app.JobCleanupResult
+
+// RestoreResult describes what was done by Job.Restore.
+type RestoreResult = app.JobRestoreResult
+
+// VerifyResult is verification result for single archive.
+type VerifyResult = app.JobVerifyResult
+
+// Plan describes what next Job.Run would do.
+type Plan = app.JobPlan
+
+// PlanRule is single rule checked to choose between full and diff archive.
+type PlanRule = app.JobPlanRule
+
+// ArchivePin is hold on archive set by Job.Pin (or Run with Options.Pin).
+type ArchivePin = app.JobArchivePin
+
+// TrashItem is single archive in trash directory (see 'trash_retention_days' setting).
+type TrashItem = app.JobTrashItem
+
+// MigrateItem is single archive rename done (or planned) by Job.Migrate.
+type MigrateItem = app.JobMigrateItem
+
+// SevenZip describes 7-Zip executable.
+type SevenZip = app.SevenZip
+
+// Error types returned by Job methods (see app package for details).
+type (
+	Warning       = app.Warning
+	ArchiverError = app.ArchiverError
+	CommandError  = app.CommandError
+	SettingsError = app.SettingsError
+	ScanError     = app.ScanError
+	DeleteError   = app.DeleteError
+	LogError      = app.LogError
+	SpaceError    = app.SpaceError
+	LockError     = app.LockError
+)
+
+// DetectSevenZip checks given 7-Zip command or tries to find 7-Zip if cmd is empty or "auto".
+// Found command should be passed to jobs in Options.SevenZipCmd.
+func DetectSevenZip(cmd string) (SevenZip, error) {
+	return app.DetectSevenZip(cmd)
+}
+
+// IsWarning checks if err is warning only (job was done anyway).
+func IsWarning(err error) bool {
+	return app.IsWarning(err)
+}
+
+// ExitCode returns process exit code for error returned by Job methods.
+func ExitCode(err error) int {
+	return app.ExitCode(err)
+}
+
+// Job is backup job for single directory. Job methods should not be called concurrently,
+// use separate jobs for that. Close should be called when job is not needed anymore.
+type Job struct {
+	job *app.Job
+}
+
+// NewJob creates job for directory. Settings are loaded from settings file in directory
+// (or from options.SettingsFilename).
+func NewJob(path string, options Options) (*Job, error) {
+	job, err := app.NewJob(path, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{job: job}, nil
+}
+
+// NewJobFromArchives creates job using settings copy stored in archives directory by Run.
+// Such job can be used to restore archives without source directory.
+func NewJobFromArchives(archives_path string, options Options) (*Job, error) {
+	job, err := app.NewJobFromArchives(archives_path, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{job: job}, nil
+}
+
+// Path returns job directory absolute path.
+func (j *Job) Path() string {
+	return j.job.Path
+}
+
+// Settings returns job settings.
+func (j *Job) Settings() *Settings {
+	return &j.job.Settings
+}
+
+// SettingsFilename returns path to job settings file.
+func (j *Job) SettingsFilename() string {
+	return j.job.SettingsFilename()
+}
+
+// Log writes message to screen and job log file.
+func (j *Job) Log(format string, args ...any) {
+	j.job.Log(format, args...)
+}
+
+// Close closes job log file.
+func (j *Job) Close() error {
+	return j.job.Close()
+}
+
+// Run creates new archive (full or diff one, according to settings) and cleans up old ones.
+// *Warning is returned if archive was created but something went wrong.
+func (j *Job) Run(ctx context.Context) (result RunResult, err error) {
+	err = j.with(ctx, func() error {
+		result, err = j.job.Run()
+		return err
+	})
+
+	return result, err
+}
+
+// Cleanup deletes old archives according to retention settings.
+func (j *Job) Cleanup(ctx context.Context) (result CleanupResult, err error) {
+	err = j.with(ctx, func() error {
+		result, err = j.job.Cleanup()
+		return err
+	})
+
+	return result, err
+}
+
+// Plan tells what Run would do now (which archive would be created and why, which archives would be
+// deleted) without changing anything. Create job with Options.DryRun to avoid creating archives
+// directory and log file by NewJob.
+func (j *Job) Plan(ctx context.Context) (plan Plan, err error) {
+	err = j.with(ctx, func() error {
+		plan, err = j.job.Plan()
+		return err
+	})
+
+	return plan, err
+}
+
+// Scan reads archives directory. Returned value is not changed by later calls.
+func (j *Job) Scan(ctx context.Context) (archive Archive, err error) {
+	err = j.with(ctx, func() error {
+		if err := j.job.ScanArchive(false); err != nil {
+			return err
+		}
+
+		archive = j.job.Archive
+
+		return nil
+	})
+
+	return archive, err
+}
+
+// Restore unpacks archive chosen by selector (with all archives it is based on) to directory.
+// Directory should not exist or should be empty. If paths are given only matching files are restored.
+func (j *Job) Restore(ctx context.Context, to string, selector ArchiveSelector, paths []string) (result RestoreResult, err error) {
+	err = j.with(ctx, func() error {
+		if err := j.job.ScanArchive(true); err != nil {
+			return err
+		}
+
+		file, err := j.job.Archive.Select(selector)
+		if err != nil {
+			return err
+		}
+
+		result, err = j.job.Restore(to, file, paths)
+
+		return err
+	})
+
+	return result, err
+}
+
+// Verify checks archives integrity and full+diff chains. If latest_only is set only
+// latest archive with all archives it is based on are checked.
+func (j *Job) Verify(ctx context.Context, latest_only bool) (results []VerifyResult, err error) {
+	err = j.with(ctx, func() error {
+		results, err = j.job.Verify(latest_only)
+		return err
+	})
+
+	return results, err
+}
+
+// LoadPreviousSettings loads settings file archives were created with, to be passed to Migrate.
+func (j *Job) LoadPreviousSettings(filename string) (Settings, error) {
+	return j.job.LoadPreviousSettings(filename)
+}
+
+// Migrate renames archives created with previous naming settings (from) to match current job settings.
+// Nothing is renamed if dry_run is set, planned renames are returned only.
+func (j *Job) Migrate(ctx context.Context, from Settings, dry_run bool) (items []MigrateItem, err error) {
+	err = j.with(ctx, func() error {
+		items, err = j.job.Migrate(from, dry_run)
+		return err
+	})
+
+	return items, err
+}
+
+// Pin puts hold on archive with given filename: Cleanup never deletes it with all archives needed to restore it.
+func (j *Job) Pin(ctx context.Context, name string, reason string) (file *ArchiveFile, err error) {
+	err = j.with(ctx, func() error {
+		file, err = j.job.Pin(name, reason)
+		return err
+	})
+
+	return file, err
+}
+
+// Unpin removes hold from archive with given filename.
+func (j *Job) Unpin(ctx context.Context, name string) (file *ArchiveFile, err error) {
+	err = j.with(ctx, func() error {
+		file, err = j.job.Unpin(name)
+		return err
+	})
+
+	return file, err
+}
+
+// TrashList returns archives in trash directory (oldest first).
+func (j *Job) TrashList() ([]TrashItem, error) {
+	return j.job.TrashList()
+}
+
+// TrashRestore moves archive with given filename from trash back to archives directory.
+func (j *Job) TrashRestore(ctx context.Context, name string) (item TrashItem, err error) {
+	err = j.with(ctx, func() error {
+		item, err = j.job.TrashRestore(name)
+		return err
+	})
+
+	return item, err
+}
+
+// TrashPurge deletes archives from trash permanently: all of them or expired ones only.
+func (j *Job) TrashPurge(ctx context.Context, expired_only bool) (purged []string, err error) {
+	err = j.with(ctx, func() error {
+		purged, err = j.job.TrashPurge(expired_only)
+		return err
+	})
+
+	return purged, err
+}
+
+// Dump prints archives list and FULL -> DIFF[] tree to screen.
+func (j *Job) Dump(ctx context.Context) error {
+	return j.with(ctx, j.job.Dump)
+}
+
+// ParseTime parses time given by user for ArchiveSelector.
+func (j *Job) ParseTime(value string) (time.Time, error) {
+	return j.job.ParseSelectorTime(value)
+}
+
+// runs f with job operations bound to ctx
+func (j *Job) with(ctx context.Context, f func() error) error {
+	j.job.SetContext(ctx)
+	defer j.job.SetContext(nil)
+
+	return f()
+}
@@ -0,0 +1,71 @@
+package saver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "src")
+
+	if err := os.MkdirAll(filepath.Join(path, "dir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		DefaultSettingsFilename:          `{"archiver": "tar.zst"}`,
+		filepath.Join("dir", "file.txt"): "file content",
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(path, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job, err := NewJob(path, Options{NoLog: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.Close()
+
+	if job.Settings().Archiver != "tar.zst" {
+		t.Fatalf("settings file is not loaded: archiver = %s", job.Settings().Archiver)
+	}
+
+	result, err := job.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Created == "" {
+		t.Fatal("archive is not created")
+	}
+
+	archive, err := job.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archive.FilesList) != 1 || archive.FilesList[0].Name != result.Created {
+		t.Fatalf("created archive is not found: %+v", archive.FilesList)
+	}
+
+	to := filepath.Join(t.TempDir(), "restored")
+	if _, err := job.Restore(context.Background(), to, ArchiveSelector{Latest: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(to, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != content {
+			t.Errorf("%s restored with content: %s", name, data)
+		}
+	}
+}